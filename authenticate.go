@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"Chirpy/internal/auth"
+
+	"github.com/google/uuid"
+)
+
+// scopeLoginOnly is for things personal access tokens and OAuth clients
+// may never do, like managing sessions, two-factor authentication or other
+// tokens.
+const scopeLoginOnly auth.Scope = ""
+
+var errMissingScope = errors.New("token doesn't have the required scope")
+
+// authenticate returns the user a request acts for, or responds with an
+// error and returns false. A login's access JWT may do anything. A
+// personal access token or an OAuth client's access JWT needs scope.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request, scope auth.Scope) (uuid.UUID, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, false
+	}
+
+	userID, err := cfg.userFromToken(r.Context(), token, scope)
+	if errors.Is(err, errMissingScope) {
+		respondWithError(w, http.StatusForbidden, "Token isn't allowed to do this", err)
+		return uuid.Nil, false
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate personal access token", err)
+		return uuid.Nil, false
+	}
+	if err != nil && auth.IsPersonalAccessToken(token) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't validate personal access token", err)
+		return uuid.Nil, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// userFromToken checks a bearer token and returns the user it acts for.
+func (cfg *apiConfig) userFromToken(ctx context.Context, token string, scope auth.Scope) (uuid.UUID, error) {
+	if !auth.IsPersonalAccessToken(token) {
+		claims, err := cfg.keyring.ValidateAccessToken(token)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if claims.ClientID != "" && (scope == scopeLoginOnly || !slices.Contains(claims.Scopes(), scope)) {
+			return uuid.Nil, errMissingScope
+		}
+		return claims.UserID()
+	}
+	if scope == scopeLoginOnly {
+		return uuid.Nil, errMissingScope
+	}
+
+	pat, err := cfg.db.GetPersonalAccessTokenByHash(ctx, auth.HashToken(token))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !slices.Contains(pat.Scopes, string(scope)) {
+		return uuid.Nil, errMissingScope
+	}
+
+	err = cfg.db.TouchPersonalAccessToken(ctx, pat.ID)
+	if err != nil {
+		log.Printf("Couldn't update last use of personal access token %s: %s", pat.ID, err)
+	}
+	return pat.UserID, nil
+}
+
+// isDelegatedToken reports whether token acts for a user without being a
+// login, i.e. it's a personal access token or an OAuth client's JWT.
+func (cfg *apiConfig) isDelegatedToken(token string) bool {
+	if auth.IsPersonalAccessToken(token) {
+		return true
+	}
+	claims, err := cfg.keyring.ValidateAccessToken(token)
+	return err == nil && claims.ClientID != ""
+}
+
+// authenticateAdmin is authenticate for admin endpoints, which need an
+// admin's login.
+func (cfg *apiConfig) authenticateAdmin(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return uuid.Nil, false
+	}
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find user", err)
+		return uuid.Nil, false
+	}
+	if !user.IsAdmin {
+		respondWithError(w, http.StatusForbidden, "Only admins can do this", nil)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
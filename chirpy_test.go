@@ -1 +1,2223 @@
 package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+	"Chirpy/internal/entitlements"
+	"Chirpy/internal/mailer"
+	"Chirpy/internal/moderation"
+	"Chirpy/internal/throttle"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBuildTSQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Single word",
+			q:    "chirpy",
+			want: "chirpy",
+		},
+		{
+			name: "Words are ANDed",
+			q:    "hello  world",
+			want: "hello & world",
+		},
+		{
+			name: "Phrase",
+			q:    `"hello world" again`,
+			want: "(hello <-> world) & again",
+		},
+		{
+			name: "Prefix",
+			q:    "chirp*",
+			want: "chirp:*",
+		},
+		{
+			name: "Operators are stripped",
+			q:    "a&b | !c* ()",
+			want: "a <-> b & c:*",
+		},
+		{
+			name: "Punctuation makes a phrase",
+			q:    "e-mail don't",
+			want: "e <-> mail & don <-> t",
+		},
+		{
+			name:    "Empty query",
+			q:       `  "" !!`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildTSQuery(tt.q)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("buildTSQuery() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("buildTSQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchField(t *testing.T) {
+	type patch struct {
+		Bio patchField[string] `json:"bio"`
+	}
+
+	tests := []struct {
+		name     string
+		doc      string
+		wantSet  bool
+		wantNull bool
+		want     string
+	}{
+		{
+			name: "Absent member",
+			doc:  `{}`,
+		},
+		{
+			name:     "Null member",
+			doc:      `{"bio": null}`,
+			wantSet:  true,
+			wantNull: true,
+		},
+		{
+			name:    "Value",
+			doc:     `{"bio": "hello"}`,
+			wantSet: true,
+			want:    "hello",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := patch{}
+			if err := json.Unmarshal([]byte(tt.doc), &p); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if p.Bio.Set != tt.wantSet || p.Bio.Null != tt.wantNull || p.Bio.Value != tt.want {
+				t.Errorf("got %+v, want Set=%v Null=%v Value=%q", p.Bio, tt.wantSet, tt.wantNull, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"too long", 3, "too"},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.in, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		wantErr bool
+	}{
+		{"https://app.example.com/callback", false},
+		{"http://localhost:8080/callback", false},
+		{"http://127.0.0.1/callback", false},
+		{"http://app.example.com/callback", true},
+		{"https://app.example.com/callback#token", true},
+		{"/callback", true},
+		{"javascript:alert(1)", true},
+	}
+	for _, tt := range tests {
+		if err := validateRedirectURI(tt.uri); (err != nil) != tt.wantErr {
+			t.Errorf("validateRedirectURI(%q) error = %v, wantErr %v", tt.uri, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPolkaWebhookSignature(t *testing.T) {
+	userID := uuid.MustParse("3311741c-680c-4546-99f3-fc9efac2036c")
+	body := `{"event":"user.downgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`
+
+	tests := []struct {
+		name      string
+		signature string
+		want      int
+	}{
+		{"Signed", auth.SignWebhook([]byte(body), time.Now(), "secret"), http.StatusNoContent},
+		{"Unsigned", "", http.StatusUnauthorized},
+		{"Wrong secret", auth.SignWebhook([]byte(body), time.Now(), "guess"), http.StatusUnauthorized},
+		{"Replayed", auth.SignWebhook([]byte(body), time.Now().Add(-time.Hour), "secret"), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, fake, _ := newTestConfig(t, userID)
+			cfg.polkaSecrets = []string{"secret"}
+			event := database.WebhookEvent{ID: uuid.New(), EventType: "user.downgraded", Payload: json.RawMessage(body), Status: webhookStatusProcessing, Attempts: 1}
+			newFakeSubscriptions(fake, database.User{ID: userID, Email: "user@example.com"})
+			fake.on("ClaimWebhookEvent", func(args []driver.Value) ([][]driver.Value, error) {
+				event.SentAt = args[3].(time.Time)
+				return [][]driver.Value{fakeRow(event)}, nil
+			})
+			fake.on("FinishWebhookEvent", func(args []driver.Value) ([][]driver.Value, error) {
+				event.Status = args[1].(string)
+				return [][]driver.Value{fakeRow(event)}, nil
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+			if tt.signature != "" {
+				req.Header.Set(auth.PolkaSignatureHeader, tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			cfg.handlerPolka(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("handlerPolka() status = %d, want %d", rec.Code, tt.want)
+			}
+
+			// rejected webhooks don't touch the event log
+			claimed := len(fake.called("ClaimWebhookEvent")) > 0
+			if claimed != (tt.want == http.StatusNoContent) {
+				t.Errorf("event claimed = %v, want %v", claimed, !claimed)
+			}
+			if tt.want == http.StatusNoContent && event.Status != webhookStatusProcessed {
+				t.Errorf("event status = %q, want %q", event.Status, webhookStatusProcessed)
+			}
+		})
+	}
+}
+
+func TestPolkaEventID(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	sentAt := time.Unix(1700000000, 0)
+	if got := polkaEventID(polkaEvent{ID: "evt_1"}, body, sentAt); got != "evt_1" {
+		t.Errorf("polkaEventID() = %q, want %q", got, "evt_1")
+	}
+	withoutID := polkaEventID(polkaEvent{}, body, sentAt)
+	if !strings.HasPrefix(withoutID, "sha256:") || withoutID != polkaEventID(polkaEvent{}, body, sentAt) {
+		t.Errorf("polkaEventID() without an ID = %q, want a stable hash", withoutID)
+	}
+	if withoutID == polkaEventID(polkaEvent{}, []byte(`{"event":"user.upgraded"}`), sentAt) {
+		t.Error("polkaEventID() is the same for different bodies")
+	}
+	// upgraded, downgraded, then upgraded again with the same body
+	if withoutID == polkaEventID(polkaEvent{}, body, sentAt.Add(time.Hour)) {
+		t.Error("polkaEventID() is the same for the same body sent at different times")
+	}
+}
+
+// fakeSubscriptions keeps one user's subscription and answers the
+// subscription queries like Postgres would.
+type fakeSubscriptions struct {
+	user database.User
+	sub  *database.Subscription
+}
+
+func newFakeSubscriptions(fake *fakeDB, user database.User) *fakeSubscriptions {
+	f := &fakeSubscriptions{user: user}
+	nullTime := func(v driver.Value) sql.NullTime {
+		at, ok := v.(time.Time)
+		return sql.NullTime{Time: at, Valid: ok}
+	}
+	// the guard of the updates: not ended, and no newer event applied
+	current := func(eventAt driver.Value) bool {
+		return f.sub != nil && f.sub.Status != "ended" && !f.sub.UpdatedAt.After(eventAt.(time.Time))
+	}
+	subRows := func() [][]driver.Value {
+		return [][]driver.Value{fakeRow(*f.sub)}
+	}
+
+	fake.on("GetUserById", func([]driver.Value) ([][]driver.Value, error) {
+		return [][]driver.Value{fakeRow(f.user)}, nil
+	})
+	fake.on("GetSubscriptionForUpdate", func([]driver.Value) ([][]driver.Value, error) {
+		if f.sub == nil {
+			return nil, nil
+		}
+		return subRows(), nil
+	})
+	fake.on("UpsertSubscription", func(args []driver.Value) ([][]driver.Value, error) {
+		eventAt := args[1].(time.Time)
+		if f.sub != nil && f.sub.UpdatedAt.After(eventAt) {
+			return nil, nil
+		}
+		createdAt := time.Now()
+		if f.sub != nil {
+			createdAt = f.sub.CreatedAt
+		}
+		f.sub = &database.Subscription{
+			UserID:    f.user.ID,
+			CreatedAt: createdAt,
+			UpdatedAt: eventAt,
+			Plan:      args[2].(string),
+			Status:    "active",
+			ExpiresAt: nullTime(args[3]),
+		}
+		return subRows(), nil
+	})
+	fake.on("CancelSubscription", func(args []driver.Value) ([][]driver.Value, error) {
+		if !current(args[1]) {
+			return nil, nil
+		}
+		f.sub.Status = "canceled"
+		f.sub.CanceledAt = sql.NullTime{Time: time.Now(), Valid: true}
+		if expiresAt := nullTime(args[0]); expiresAt.Valid {
+			f.sub.ExpiresAt = expiresAt
+		}
+		f.sub.UpdatedAt = args[1].(time.Time)
+		return subRows(), nil
+	})
+	fake.on("MarkSubscriptionPastDue", func(args []driver.Value) ([][]driver.Value, error) {
+		if !current(args[1]) {
+			return nil, nil
+		}
+		f.sub.Status = "past_due"
+		f.sub.ExpiresAt = nullTime(args[0])
+		f.sub.UpdatedAt = args[1].(time.Time)
+		return subRows(), nil
+	})
+	fake.on("EndSubscription", func(args []driver.Value) ([][]driver.Value, error) {
+		if !current(args[0]) {
+			return nil, nil
+		}
+		f.sub.Status = "ended"
+		f.sub.ExpiresAt = sql.NullTime{Time: time.Now(), Valid: true}
+		f.sub.UpdatedAt = args[0].(time.Time)
+		return [][]driver.Value{nil}, nil
+	})
+	fake.on("SyncChirpyRed", func([]driver.Value) ([][]driver.Value, error) {
+		f.user.IsChirpyRed = f.sub != nil && f.sub.Status != "ended" &&
+			(!f.sub.ExpiresAt.Valid || f.sub.ExpiresAt.Time.After(time.Now()))
+		return [][]driver.Value{fakeRow(f.user)}, nil
+	})
+	fake.on("EndLapsedSubscriptions", func([]driver.Value) ([][]driver.Value, error) {
+		if f.sub == nil || f.sub.Status == "ended" || !f.sub.ExpiresAt.Valid || f.sub.ExpiresAt.Time.After(time.Now()) {
+			return nil, nil
+		}
+		f.sub.Status = "ended"
+		f.user.IsChirpyRed = false
+		return [][]driver.Value{{f.user.ID.String()}}, nil
+	})
+	return f
+}
+
+func TestApplyPolkaEvent(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "user@example.com"}
+	cfg, fake, _ := newTestConfig(t, user.ID)
+	subs := newFakeSubscriptions(fake, user)
+	start := time.Now().UTC().Add(-time.Hour)
+	inDays := func(days int) *time.Time {
+		at := time.Now().UTC().AddDate(0, 0, days)
+		return &at
+	}
+
+	// applied in order, each on the state the ones before left
+	steps := []struct {
+		name        string
+		event       string
+		expiresAt   *time.Time
+		sentAfter   time.Duration
+		wantApplied bool
+		wantErr     error
+		wantStatus  string
+		wantRed     bool
+	}{
+		{"Upgraded", "user.upgraded", nil, 0, true, nil, "active", true},
+		{"Downgraded", "user.downgraded", nil, time.Minute, true, nil, "ended", false},
+		{"Upgraded again", "user.upgraded", nil, 2 * time.Minute, true, nil, "active", true},
+		{"Renewed without expiry", "subscription.renewed", nil, 3 * time.Minute, true, errWebhookMissingExpiry, "active", true},
+		{"Renewed", "subscription.renewed", inDays(30), 4 * time.Minute, true, nil, "active", true},
+		{"Payment failed without expiry", "subscription.payment_failed", nil, 5 * time.Minute, true, errWebhookMissingExpiry, "active", true},
+		{"Payment failed", "subscription.payment_failed", inDays(3), 6 * time.Minute, true, nil, "past_due", true},
+		{"Stale downgrade", "user.downgraded", nil, 5*time.Minute + 30*time.Second, false, nil, "past_due", true},
+		{"Canceled keeping the expiry", "subscription.canceled", nil, 7 * time.Minute, true, nil, "canceled", true},
+		{"Unknown event", "user.renamed", nil, 8 * time.Minute, false, nil, "canceled", true},
+	}
+	for _, step := range steps {
+		event := polkaEvent{Event: step.event}
+		event.Data.UserID = user.ID.String()
+		event.Data.ExpiresAt = step.expiresAt
+		payload, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		applied, err := cfg.applyPolkaEvent(context.Background(), payload, start.Add(step.sentAfter))
+		if applied != step.wantApplied || !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: applyPolkaEvent() = %v, %v, want %v, %v", step.name, applied, err, step.wantApplied, step.wantErr)
+		}
+		if subs.sub.Status != step.wantStatus || subs.user.IsChirpyRed != step.wantRed {
+			t.Fatalf("%s: subscription %s, Chirpy Red %v, want %s, %v", step.name, subs.sub.Status, subs.user.IsChirpyRed, step.wantStatus, step.wantRed)
+		}
+	}
+	// canceling keeps the expiry
+	if !subs.sub.ExpiresAt.Valid || !subs.sub.ExpiresAt.Time.After(time.Now()) {
+		t.Errorf("canceled subscription expires at %v, want the expiry kept", subs.sub.ExpiresAt)
+	}
+}
+
+func TestApplyPolkaEventCancelWithoutExpiry(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "user@example.com"}
+	cfg, fake, _ := newTestConfig(t, user.ID)
+	subs := newFakeSubscriptions(fake, user)
+	start := time.Now().UTC().Add(-time.Hour)
+	apply := func(name string, sentAfter time.Duration, expiresAt *time.Time) error {
+		event := polkaEvent{Event: name}
+		event.Data.UserID = user.ID.String()
+		event.Data.ExpiresAt = expiresAt
+		payload, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cfg.applyPolkaEvent(context.Background(), payload, start.Add(sentAfter))
+		return err
+	}
+
+	if err := apply("user.upgraded", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	// nothing would ever end a subscription canceled without an expiry
+	if err := apply("subscription.canceled", time.Minute, nil); !errors.Is(err, errWebhookMissingExpiry) {
+		t.Fatalf("cancel without expiry: err = %v, want %v", err, errWebhookMissingExpiry)
+	}
+	if subs.sub.Status != "active" {
+		t.Fatalf("subscription %s after a refused cancel, want active", subs.sub.Status)
+	}
+
+	expiresAt := time.Now().UTC().Add(-time.Minute)
+	if err := apply("subscription.canceled", 2*time.Minute, &expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	cfg.sweepSubscriptions(context.Background())
+	if subs.sub.Status != "ended" || subs.user.IsChirpyRed {
+		t.Errorf("subscription %s, Chirpy Red %v, want ended, false", subs.sub.Status, subs.user.IsChirpyRed)
+	}
+}
+
+func TestSweepSubscriptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		expiresAt  sql.NullTime
+		wantStatus string
+		wantRed    bool
+	}{
+		{"Lapsed", sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}, "ended", false},
+		{"Running", sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}, "canceled", true},
+		{"Without expiry", sql.NullTime{}, "canceled", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := database.User{ID: uuid.New(), IsChirpyRed: true}
+			cfg, fake, _ := newTestConfig(t, user.ID)
+			subs := newFakeSubscriptions(fake, user)
+			subs.sub = &database.Subscription{UserID: user.ID, Plan: defaultChirpyRedPlan, Status: "canceled", ExpiresAt: tt.expiresAt}
+
+			cfg.sweepSubscriptions(context.Background())
+			if len(fake.called("EndLapsedSubscriptions")) != 1 {
+				t.Fatal("sweepSubscriptions() didn't end lapsed subscriptions")
+			}
+			if subs.sub.Status != tt.wantStatus || subs.user.IsChirpyRed != tt.wantRed {
+				t.Errorf("subscription %s, Chirpy Red %v, want %s, %v", subs.sub.Status, subs.user.IsChirpyRed, tt.wantStatus, tt.wantRed)
+			}
+		})
+	}
+}
+
+func TestCheckChirpRate(t *testing.T) {
+	userID := uuid.New()
+	cfg, fake, _ := newTestConfig(t, userID)
+	window := database.ChirpRateLimit{UserID: userID}
+	fake.on("AddChirpAttempt", func(args []driver.Value) ([][]driver.Value, error) {
+		if !window.WindowStart.After(args[2].(time.Time)) {
+			window.WindowStart = args[1].(time.Time)
+			window.Attempts = 0
+		}
+		window.Attempts++
+		return [][]driver.Value{fakeRow(window)}, nil
+	})
+	ent := entitlements.Entitlements{ChirpsPerHour: 3}
+
+	for i := range 4 {
+		rec := httptest.NewRecorder()
+		ok := cfg.checkChirpRate(rec, httptest.NewRequest(http.MethodPost, "/api/chirps", nil), userID, ent)
+		if want := i < 3; ok != want {
+			t.Fatalf("attempt %d: checkChirpRate() = %v, want %v", i+1, ok, want)
+		}
+		if !ok && (rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "") {
+			t.Errorf("attempt %d: status %d, Retry-After %q, want 429 with Retry-After", i+1, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+
+	// the window starts over after an hour
+	window.WindowStart = window.WindowStart.Add(-time.Hour)
+	if !cfg.checkChirpRate(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/chirps", nil), userID, ent) {
+		t.Error("checkChirpRate() after the hour = false, want true")
+	}
+}
+
+func TestModerationQueueApprove(t *testing.T) {
+	parentID := uuid.New()
+	editedID := uuid.New()
+	tests := []struct {
+		name          string
+		held          database.HeldChirp
+		parentDeleted bool
+		postedAgo     time.Duration
+		wantCode      int
+	}{
+		{"Reply", database.HeldChirp{InReplyTo: uuid.NullUUID{UUID: parentID, Valid: true}}, false, 0, http.StatusOK},
+		{"Reply to deleted chirp", database.HeldChirp{InReplyTo: uuid.NullUUID{UUID: parentID, Valid: true}}, true, 0, http.StatusConflict},
+		{"Quote", database.HeldChirp{RechirpOf: uuid.NullUUID{UUID: parentID, Valid: true}}, false, 0, http.StatusOK},
+		{"Quote of deleted chirp", database.HeldChirp{RechirpOf: uuid.NullUUID{UUID: parentID, Valid: true}}, true, 0, http.StatusConflict},
+		{"Edit", database.HeldChirp{ChirpID: uuid.NullUUID{UUID: editedID, Valid: true}}, false, time.Minute, http.StatusOK},
+		{"Edit past the window", database.HeldChirp{ChirpID: uuid.NullUUID{UUID: editedID, Valid: true}}, false, 2 * time.Hour, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adminID := uuid.New()
+			cfg, fake, token := newTestConfig(t, adminID)
+			cfg.plans = entitlements.Default()
+			held := tt.held
+			held.ID = uuid.New()
+			held.UserID = uuid.New()
+			held.Body = "held"
+			held.Status = heldChirpPending
+
+			fake.on("GetUserById", func(args []driver.Value) ([][]driver.Value, error) {
+				id := fakeUUID(args[0])
+				return [][]driver.Value{fakeRow(database.User{ID: id, IsAdmin: id == adminID})}, nil
+			})
+			fake.on("ReviewHeldChirp", func([]driver.Value) ([][]driver.Value, error) {
+				held.Status = heldChirpApproved
+				return [][]driver.Value{fakeRow(held)}, nil
+			})
+			fake.on("GetChirpForUpdate", func(args []driver.Value) ([][]driver.Value, error) {
+				id := fakeUUID(args[0])
+				if id == parentID && tt.parentDeleted {
+					return nil, nil
+				}
+				return [][]driver.Value{fakeRow(database.Chirp{ID: id, CreatedAt: time.Now().Add(-tt.postedAgo), UserID: held.UserID})}, nil
+			})
+			published := func([]driver.Value) ([][]driver.Value, error) {
+				return [][]driver.Value{fakeRow(database.Chirp{ID: uuid.New(), Body: held.Body, UserID: held.UserID})}, nil
+			}
+			for _, name := range []string{"UpdateChirpBody", "CreateQuoteChirp", "CreateChirp"} {
+				fake.on(name, published)
+			}
+			fake.on("CreateChirpRevision", func([]driver.Value) ([][]driver.Value, error) {
+				return [][]driver.Value{fakeRow(database.ChirpRevision{ID: uuid.New()})}, nil
+			})
+			fake.on("SetHeldChirpChirp", func([]driver.Value) ([][]driver.Value, error) {
+				return [][]driver.Value{fakeRow(held)}, nil
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/moderation/queue/"+held.ID.String()+"/approve", nil)
+			req.SetPathValue("heldID", held.ID.String())
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			cfg.handlerModerationQueueApprove(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("handlerModerationQueueApprove() status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			// a refused approval leaves the chirp in the queue
+			committed := len(fake.called("COMMIT")) > 0
+			if committed != (tt.wantCode == http.StatusOK) {
+				t.Errorf("committed = %v, want %v", committed, !committed)
+			}
+		})
+	}
+}
+
+func TestModeratorSkipsInvalidRules(t *testing.T) {
+	fake, queries, _ := newFakeDB(t)
+	fake.on("ListModerationRules", func([]driver.Value) ([][]driver.Value, error) {
+		return [][]driver.Value{
+			fakeRow(database.ModerationRule{ID: uuid.New(), Kind: "regex", Pattern: "(unclosed", Action: "reject"}),
+			fakeRow(database.ModerationRule{ID: uuid.New(), Kind: "word", Pattern: "grawlix", Action: "hold"}),
+		}, nil
+	})
+	m := &moderator{db: queries, words: moderation.DefaultWords}
+
+	pipeline, err := m.Pipeline(context.Background())
+	if err != nil {
+		t.Fatalf("Pipeline() error = %v", err)
+	}
+	if v := pipeline.Check("a grawlix"); v.Action != moderation.Hold {
+		t.Errorf("Check() = %s, want the valid rule to still apply", v.Action)
+	}
+}
+
+func TestModeratorReloadsOutsideTheLock(t *testing.T) {
+	fake, queries, _ := newFakeDB(t)
+	block := make(chan struct{})
+	blocking := false
+	var mu sync.Mutex
+	fake.on("ListModerationRules", func([]driver.Value) ([][]driver.Value, error) {
+		mu.Lock()
+		b := blocking
+		mu.Unlock()
+		if b {
+			<-block
+		}
+		return nil, nil
+	})
+	m := &moderator{db: queries, words: moderation.DefaultWords}
+	old, err := m.Pipeline(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	blocking = true
+	mu.Unlock()
+	m.Invalidate()
+	reloaded := make(chan *moderation.Pipeline)
+	go func() {
+		p, _ := m.Pipeline(context.Background())
+		reloaded <- p
+	}()
+	// wait until the reload is stuck in the database
+	for {
+		m.mu.Lock()
+		r := m.reloading
+		m.mu.Unlock()
+		if r {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	got := make(chan *moderation.Pipeline)
+	go func() {
+		p, _ := m.Pipeline(context.Background())
+		got <- p
+	}()
+	select {
+	case p := <-got:
+		if p != old {
+			t.Error("Pipeline() during a reload didn't return the previous pipeline")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Pipeline() waited for another caller's reload")
+	}
+
+	close(block)
+	if p := <-reloaded; p == old {
+		t.Error("Pipeline() didn't reload after Invalidate()")
+	}
+}
+
+func TestPageCursor(t *testing.T) {
+	for _, backward := range []bool{false, true} {
+		want := pageCursor{CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC), ID: uuid.New(), Backward: backward}
+		got, err := decodePageCursor(want.encode())
+		if err != nil {
+			t.Fatalf("decodePageCursor() error = %v", err)
+		}
+		if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID || got.Backward != want.Backward {
+			t.Errorf("decodePageCursor() = %+v, want %+v", got, want)
+		}
+	}
+
+	for _, s := range []string{"not base64!", "bm90IGpzb24", pageCursor{CreatedAt: time.Now()}.encode()} {
+		if _, err := decodePageCursor(s); err == nil {
+			t.Errorf("decodePageCursor(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	type item struct {
+		n  int
+		id uuid.UUID
+	}
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := make([]item, 5)
+	for i := range items {
+		items[i] = item{n: i, id: uuid.New()}
+	}
+	key := func(it item) (time.Time, uuid.UUID) {
+		return base.Add(time.Duration(it.n) * time.Minute), it.id
+	}
+	ns := func(page []item) []int {
+		out := []int{}
+		for _, it := range page {
+			out = append(out, it.n)
+		}
+		return out
+	}
+	cursorOf := func(s string) pageCursor {
+		t.Helper()
+		c, err := decodePageCursor(s)
+		if err != nil {
+			t.Fatalf("decodePageCursor(%q) error = %v", s, err)
+		}
+		return c
+	}
+
+	// first page: one row past the limit means there's a next page
+	page, next, prev := paginate(slices.Clone(items[:3]), pageParams{Limit: 2}, key)
+	if !slices.Equal(ns(page), []int{0, 1}) || prev != "" {
+		t.Fatalf("first page = %v, prev %q", ns(page), prev)
+	}
+	if c := cursorOf(next); c.ID != items[1].id || c.Backward {
+		t.Errorf("next cursor = %+v, want forward at item 1", c)
+	}
+
+	// following next: rows after item 1, and a prev cursor back
+	forward := cursorOf(next)
+	page, next, prev = paginate(slices.Clone(items[2:5]), pageParams{Limit: 2, Cursor: &forward}, key)
+	if !slices.Equal(ns(page), []int{2, 3}) {
+		t.Fatalf("second page = %v", ns(page))
+	}
+	if c := cursorOf(next); c.ID != items[3].id || c.Backward {
+		t.Errorf("next cursor = %+v, want forward at item 3", c)
+	}
+	if c := cursorOf(prev); c.ID != items[2].id || !c.Backward {
+		t.Errorf("prev cursor = %+v, want backward at item 2", c)
+	}
+
+	// following prev: rows are scanned in reverse and flipped back
+	backward := cursorOf(prev)
+	page, next, prev = paginate([]item{items[1], items[0]}, pageParams{Limit: 2, Cursor: &backward}, key)
+	if !slices.Equal(ns(page), []int{0, 1}) {
+		t.Fatalf("previous page = %v, want it in the requested order", ns(page))
+	}
+	if prev != "" {
+		t.Errorf("prev cursor = %q, want none on the first page", prev)
+	}
+	if c := cursorOf(next); c.ID != items[1].id || c.Backward {
+		t.Errorf("next cursor = %+v, want forward at item 1", c)
+	}
+
+	// last page
+	page, next, _ = paginate(slices.Clone(items[4:]), pageParams{Limit: 2, Cursor: &forward}, key)
+	if len(page) != 1 || next != "" {
+		t.Errorf("last page = %v, next %q, want one item and no next cursor", ns(page), next)
+	}
+
+	page, next, prev = paginate([]item{}, pageParams{Limit: 2, Cursor: &forward}, key)
+	if len(page) != 0 || next != "" || prev != "" {
+		t.Errorf("empty page = %v, %q, %q", ns(page), next, prev)
+	}
+}
+
+func TestChirpsRetrievePage(t *testing.T) {
+	cfg, fake, _ := newTestConfig(t, uuid.New())
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := [][]driver.Value{}
+	for i := range 3 {
+		rows = append(rows, fakeRow(database.Chirp{ID: uuid.New(), Body: fmt.Sprintf("chirp %d", i), CreatedAt: base.Add(time.Duration(i) * time.Minute)}))
+	}
+	fake.on("ListChirpsAsc", func(args []driver.Value) ([][]driver.Value, error) {
+		return rows[:args[3].(int64)], nil
+	})
+	fake.on("GetChirpLikeStats", func([]driver.Value) ([][]driver.Value, error) {
+		return nil, nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/chirps?limit=2", nil)
+	w := httptest.NewRecorder()
+	cfg.handlerChirpsRetrieve(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	page := chirpsPage{}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("response isn't a page of chirps: %v: %s", err, w.Body)
+	}
+	if len(page.Chirps) != 2 || page.Chirps[0].Body != "chirp 0" {
+		t.Errorf("chirps = %+v, want the first two", page.Chirps)
+	}
+	if page.NextCursor == "" || page.PrevCursor != "" {
+		t.Errorf("cursors = %q, %q, want only a next cursor", page.NextCursor, page.PrevCursor)
+	}
+	if link := w.Header().Get("Link"); !strings.Contains(link, page.NextCursor) || !strings.Contains(link, `rel="next"`) {
+		t.Errorf("Link = %q, want the next cursor", link)
+	}
+}
+
+// newTestConfig returns a config backed by a fake database, and a login
+// access token for userID.
+func newTestConfig(t *testing.T, userID uuid.UUID) (*apiConfig, *fakeDB, string) {
+	t.Helper()
+	fake, queries, conn := newFakeDB(t)
+	keyring, err := auth.NewKeyring(auth.NewHMACKey("", []byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := keyring.MakeJWT(userID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := throttle.NewMemoryStore()
+	cfg := &apiConfig{
+		dbConn:         conn,
+		db:             queries,
+		jwtSecret:      "secret",
+		keyring:        keyring,
+		passwordHasher: auth.BcryptHasher{Cost: bcrypt.MinCost},
+		passwordPolicy: auth.PasswordPolicy{MinLength: 8, MaxLength: 72, MinStrength: 2},
+		mailer:         &mailer.LogMailer{},
+
+		throttleStore:             store,
+		passwordResetEmailLimiter: throttle.NewLimiter(store, "password-reset-email:", passwordResetEmailPolicy),
+		passwordResetIPLimiter:    throttle.NewLimiter(store, "password-reset-ip:", passwordResetIPPolicy),
+		emailVerificationLimiter:  throttle.NewLimiter(store, "email-verification:", emailVerificationPolicy),
+		mailQueue:                 make(chan mailJob, mailQueueSize),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go cfg.runMailWorker(ctx)
+	return cfg, fake, token
+}
+
+func TestUsersUpdateCredentials(t *testing.T) {
+	hash, err := auth.HashPassword("old password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		body         string
+		wantCode     int
+		wantEmail    string
+		wantPassword bool
+	}{
+		{"Email only", `{"email": "new@example.com", "current_password": "old password"}`, http.StatusOK, "new@example.com", false},
+		{"Password only", `{"password": "correct horse battery staple", "current_password": "old password"}`, http.StatusOK, "old@example.com", true},
+		{"Both", `{"email": "new@example.com", "password": "correct horse battery staple", "current_password": "old password"}`, http.StatusOK, "new@example.com", true},
+		{"Weak password", `{"email": "new@example.com", "password": "short", "current_password": "old password"}`, http.StatusBadRequest, "", false},
+		{"Missing current password", `{"email": "new@example.com"}`, http.StatusForbidden, "", false},
+		{"Wrong current password", `{"password": "correct horse battery staple", "current_password": "guess"}`, http.StatusForbidden, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := database.User{ID: uuid.New(), Email: "old@example.com", HashedPassword: hash, DisplayName: "Old"}
+			cfg, fake, token := newTestConfig(t, user.ID)
+			fake.on("GetUserById", func([]driver.Value) ([][]driver.Value, error) {
+				return [][]driver.Value{fakeRow(user)}, nil
+			})
+			fake.on("UpdateUserEmail", func(args []driver.Value) ([][]driver.Value, error) {
+				user.Email = args[0].(string)
+				return [][]driver.Value{fakeRow(user)}, nil
+			})
+			fake.on("UpdateUserPassword", func(args []driver.Value) ([][]driver.Value, error) {
+				user.HashedPassword = args[0].(string)
+				return [][]driver.Value{fakeRow(user)}, nil
+			})
+			fake.on("RevokeAllRefreshTokensForUser", func(args []driver.Value) ([][]driver.Value, error) {
+				if fakeUUID(args[0]) != user.ID {
+					t.Errorf("revoked sessions of %v, want %v", args[0], user.ID)
+				}
+				return nil, nil
+			})
+
+			req := httptest.NewRequest(http.MethodPut, "/api/users", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			cfg.handlerUsersUpdate(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			committed := len(fake.called("COMMIT")) == 1
+			if tt.wantCode != http.StatusOK {
+				if committed {
+					t.Error("a refused update was committed")
+				}
+				return
+			}
+			if !committed {
+				t.Error("update wasn't committed")
+			}
+			if user.Email != tt.wantEmail {
+				t.Errorf("email = %q, want %q", user.Email, tt.wantEmail)
+			}
+			if changed := user.HashedPassword != hash; changed != tt.wantPassword {
+				t.Errorf("password changed = %v, want %v", changed, tt.wantPassword)
+			}
+			if got := len(fake.called("UpdateUserEmail")); (got == 1) != (tt.wantEmail != "old@example.com") {
+				t.Errorf("UpdateUserEmail called %d times", got)
+			}
+			if got := len(fake.called("RevokeAllRefreshTokensForUser")); (got == 1) != tt.wantPassword {
+				t.Errorf("RevokeAllRefreshTokensForUser called %d times", got)
+			}
+		})
+	}
+}
+
+// blockingMailer holds every message until release is closed.
+type blockingMailer struct {
+	release chan struct{}
+	sent    chan mailer.Message
+}
+
+func (m *blockingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	<-m.release
+	m.sent <- msg
+	return nil
+}
+
+func TestCheckLoginPassword(t *testing.T) {
+	hash, err := auth.HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := database.User{ID: uuid.New(), Email: "user@example.com", HashedPassword: hash}
+	cfg, fake, _ := newTestConfig(t, user.ID)
+	cfg.dummyPasswordHash = hash
+	fake.on("GetUserByEmail", func(args []driver.Value) ([][]driver.Value, error) {
+		if args[0] != user.Email {
+			return nil, nil
+		}
+		return [][]driver.Value{fakeRow(user)}, nil
+	})
+
+	got, err := cfg.checkLoginPassword(context.Background(), user.Email, "password")
+	if err != nil || got.ID != user.ID {
+		t.Errorf("right password: got %v, %v, want the user", got.ID, err)
+	}
+	if _, err := cfg.checkLoginPassword(context.Background(), user.Email, "guess"); err == nil {
+		t.Error("wrong password: no error")
+	}
+	// even the dummy hash's password doesn't open an unknown email
+	if _, err := cfg.checkLoginPassword(context.Background(), "unknown@example.com", "password"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("unknown email: err = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestPasswordForgotDoesntWaitForMail(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "user@example.com"}
+	cfg, fake, _ := newTestConfig(t, user.ID)
+	mail := &blockingMailer{release: make(chan struct{}), sent: make(chan mailer.Message, 1)}
+	cfg.mailer = mail
+	fake.on("GetUserByEmail", func(args []driver.Value) ([][]driver.Value, error) {
+		if args[0] != user.Email {
+			return nil, nil
+		}
+		return [][]driver.Value{fakeRow(user)}, nil
+	})
+	fake.on("CreatePasswordResetToken", func([]driver.Value) ([][]driver.Value, error) {
+		return [][]driver.Value{fakeRow(database.PasswordResetToken{UserID: user.ID})}, nil
+	})
+
+	for _, email := range []string{"unknown@example.com", user.Email} {
+		req := httptest.NewRequest(http.MethodPost, "/api/password/forgot", strings.NewReader(`{"email": "`+email+`"}`))
+		w := httptest.NewRecorder()
+		cfg.handlerPasswordForgot(w, req)
+		if w.Code != http.StatusAccepted {
+			t.Errorf("%s: code = %d, want %d", email, w.Code, http.StatusAccepted)
+		}
+	}
+
+	// the handler answered while the mail was still being sent
+	close(mail.release)
+	select {
+	case msg := <-mail.sent:
+		if msg.To != user.Email {
+			t.Errorf("mail sent to %q, want %q", msg.To, user.Email)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reset mail wasn't sent")
+	}
+}
+
+func TestPasswordForgotThrottle(t *testing.T) {
+	cfg, fake, _ := newTestConfig(t, uuid.New())
+	fake.on("GetUserByEmail", func([]driver.Value) ([][]driver.Value, error) {
+		return nil, nil
+	})
+	forgot := func(email, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/password/forgot", strings.NewReader(`{"email": "`+email+`"}`))
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		cfg.handlerPasswordForgot(w, req)
+		return w
+	}
+
+	// the same email from different clients, up to the request that
+	// starts the delay
+	for i := range passwordResetEmailPolicy.FreeAttempts + 1 {
+		if w := forgot("User@example.com", fmt.Sprintf("192.0.2.%d", i)); w.Code != http.StatusAccepted {
+			t.Fatalf("request %d: code = %d, want %d", i+1, w.Code, http.StatusAccepted)
+		}
+	}
+	w := forgot("user@example.com ", "192.0.2.100")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("email past its budget: code = %d, Retry-After %q, want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// many emails from one client
+	for i := range passwordResetIPPolicy.FreeAttempts + 1 {
+		if w := forgot(fmt.Sprintf("user%d@example.com", i), "198.51.100.1"); w.Code != http.StatusAccepted {
+			t.Fatalf("email %d: code = %d, want %d", i+1, w.Code, http.StatusAccepted)
+		}
+	}
+	if w := forgot("other@example.com", "198.51.100.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("client past its budget: code = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	// the client being throttled didn't use up the email's budget
+	if w := forgot("other@example.com", "198.51.100.2"); w.Code != http.StatusAccepted {
+		t.Errorf("other client: code = %d, want %d", w.Code, http.StatusAccepted)
+	}
+}
+
+func TestEmailVerificationSendThrottle(t *testing.T) {
+	userID := uuid.New()
+	cfg, fake, token := newTestConfig(t, userID)
+	fake.on("GetUserById", func(args []driver.Value) ([][]driver.Value, error) {
+		return [][]driver.Value{fakeRow(database.User{ID: fakeUUID(args[0]), Email: "user@example.com"})}, nil
+	})
+	failing := true
+	fake.on("CreateEmailVerificationToken", func([]driver.Value) ([][]driver.Value, error) {
+		if failing {
+			return nil, errors.New("connection reset")
+		}
+		return [][]driver.Value{fakeRow(database.EmailVerificationToken{})}, nil
+	})
+	send := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/email/verification", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.handlerEmailVerificationSend(w, req)
+		return w
+	}
+
+	// a request that sends nothing doesn't count
+	if w := send(token); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failed send: code = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	failing = false
+	for i := range emailVerificationPolicy.FreeAttempts + 1 {
+		if w := send(token); w.Code != http.StatusNoContent {
+			t.Fatalf("request %d: code = %d, want %d: %s", i+1, w.Code, http.StatusNoContent, w.Body)
+		}
+	}
+	w := send(token)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("user past their budget: code = %d, Retry-After %q, want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if got, want := len(fake.called("CreateEmailVerificationToken")), emailVerificationPolicy.FreeAttempts+2; got != want {
+		t.Errorf("%d verification emails made, want %d", got, want)
+	}
+
+	otherToken, err := cfg.keyring.MakeJWT(uuid.New(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := send(otherToken); w.Code != http.StatusNoContent {
+		t.Errorf("other user: code = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestResetClearsThrottle(t *testing.T) {
+	cfg, fake, _ := newTestConfig(t, uuid.New())
+	cfg.platform = "dev"
+	fake.on("Reset", func([]driver.Value) ([][]driver.Value, error) {
+		return nil, nil
+	})
+	ctx := context.Background()
+	for range passwordResetEmailPolicy.FreeAttempts + 1 {
+		cfg.passwordResetEmailLimiter.Attempt(ctx, "user@example.com")
+	}
+	if wait, _ := cfg.passwordResetEmailLimiter.Check(ctx, "user@example.com"); wait == 0 {
+		t.Fatal("email isn't throttled before the reset")
+	}
+
+	w := httptest.NewRecorder()
+	cfg.handlerReset(w, httptest.NewRequest(http.MethodPost, "/admin/reset", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if wait, _ := cfg.passwordResetEmailLimiter.Check(ctx, "user@example.com"); wait != 0 {
+		t.Errorf("Check() = %v after the reset, want 0", wait)
+	}
+}
+
+func TestLoadKeyringLegacySecret(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dir, "2025-06.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyRing, err := auth.NewKeyring(auth.NewHMACKey("", []byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyToken, _ := legacyRing.MakeJWT(uuid.New(), time.Hour)
+
+	tests := []struct {
+		name      string
+		keysDir   string
+		legacy    string
+		wantValid bool
+	}{
+		{"No keys", "", "", true},
+		{"Keys", dir, "", false},
+		{"Keys while switching", dir, "true", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_KEYS_DIR", tt.keysDir)
+			t.Setenv("JWT_SIGNING_KEY_ID", "2025-06")
+			t.Setenv("JWT_ACCEPT_LEGACY_HMAC", tt.legacy)
+			keyring, err := loadKeyring("secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := keyring.ValidateJWT(legacyToken); (err == nil) != tt.wantValid {
+				t.Errorf("ValidateJWT(legacy token) error = %v, want valid = %v", err, tt.wantValid)
+			}
+		})
+	}
+}
+
+// fakeRefreshTokens keeps refresh token rows by hash and answers the
+// rotation queries like Postgres would.
+type fakeRefreshTokens map[string]*database.RefreshToken
+
+func newFakeRefreshTokens(fake *fakeDB) fakeRefreshTokens {
+	tokens := fakeRefreshTokens{}
+	fake.on("GetRefreshTokenForUpdate", func(args []driver.Value) ([][]driver.Value, error) {
+		stored, ok := tokens[args[0].(string)]
+		if !ok {
+			return nil, nil
+		}
+		return [][]driver.Value{fakeRow(*stored)}, nil
+	})
+	fake.on("RotateRefreshToken", func(args []driver.Value) ([][]driver.Value, error) {
+		tokens[args[0].(string)].RotatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		return nil, nil
+	})
+	fake.on("CreateRefreshToken", func(args []driver.Value) ([][]driver.Value, error) {
+		stored := &database.RefreshToken{
+			TokenHash: args[0].(string),
+			UserID:    fakeUUID(args[1]),
+			ExpiresAt: args[2].(time.Time),
+			FamilyID:  fakeUUID(args[3]),
+		}
+		if args[6] != nil {
+			stored.ClientID = uuid.NullUUID{UUID: fakeUUID(args[6]), Valid: true}
+		}
+		tokens[stored.TokenHash] = stored
+		return [][]driver.Value{fakeRow(*stored)}, nil
+	})
+	fake.on("RevokeRefreshTokenFamily", func(args []driver.Value) ([][]driver.Value, error) {
+		for _, stored := range tokens {
+			if stored.FamilyID == fakeUUID(args[0]) && !stored.RevokedAt.Valid {
+				stored.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			}
+		}
+		return nil, nil
+	})
+	return tokens
+}
+
+// add stores a token for userID as a login would, and returns the raw token.
+func (tokens fakeRefreshTokens) add(userID uuid.UUID, expiresAt time.Time) (string, *database.RefreshToken) {
+	raw := auth.MakeOpaqueToken()
+	stored := &database.RefreshToken{
+		TokenHash: auth.HashToken(raw),
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		FamilyID:  uuid.New(),
+	}
+	tokens[stored.TokenHash] = stored
+	return raw, stored
+}
+
+func TestRefresh(t *testing.T) {
+	userID := uuid.New()
+	cfg, fake, _ := newTestConfig(t, userID)
+	tokens := newFakeRefreshTokens(fake)
+	refresh := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.handlerRefresh(w, req)
+		return w
+	}
+	type response struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	first, stored := tokens.add(userID, time.Now().Add(time.Hour))
+	w := refresh(first)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rotate: code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	resp := response{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cfg.keyring.ValidateJWT(resp.Token); err != nil || got != userID {
+		t.Errorf("access token is for %v, %v, want %v", got, err, userID)
+	}
+	second, ok := tokens[auth.HashToken(resp.RefreshToken)]
+	if !ok || second.FamilyID != stored.FamilyID || second.UserID != userID {
+		t.Fatalf("new refresh token = %+v, want one in the family of the old one", second)
+	}
+	if !stored.RotatedAt.Valid {
+		t.Error("old refresh token wasn't marked rotated")
+	}
+	if _, ok := tokens[resp.RefreshToken]; ok {
+		t.Error("new refresh token was stored unhashed")
+	}
+
+	// the old token again means it leaked: the whole family goes
+	if w := refresh(first); w.Code != http.StatusUnauthorized {
+		t.Errorf("replay: code = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if !second.RevokedAt.Valid {
+		t.Error("replay didn't revoke the token family")
+	}
+	if w := refresh(resp.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("token from a revoked family: code = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	expired, _ := tokens.add(userID, time.Now().Add(-time.Minute))
+	if w := refresh(expired); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired: code = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := refresh("unknown"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown token: code = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRefreshTokenClients(t *testing.T) {
+	userID := uuid.New()
+	clientID := uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	cfg, fake, _ := newTestConfig(t, userID)
+	tokens := newFakeRefreshTokens(fake)
+	rotate := func(token string, clientID uuid.NullUUID) error {
+		req := httptest.NewRequest(http.MethodPost, "/oauth/token", nil)
+		_, _, err := cfg.rotateRefreshToken(req, token, clientID)
+		return err
+	}
+
+	login, _ := tokens.add(userID, time.Now().Add(time.Hour))
+	if err := rotate(login, clientID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("login token used by a client: err = %v, want %v", err, sql.ErrNoRows)
+	}
+
+	app, stored := tokens.add(userID, time.Now().Add(time.Hour))
+	stored.ClientID = clientID
+	if err := rotate(app, uuid.NullUUID{}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("client token used by a login: err = %v, want %v", err, sql.ErrNoRows)
+	}
+	if err := rotate(app, uuid.NullUUID{UUID: uuid.New(), Valid: true}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("client token used by another client: err = %v, want %v", err, sql.ErrNoRows)
+	}
+	if stored.RotatedAt.Valid {
+		t.Error("a refused token was rotated")
+	}
+	if err := rotate(app, clientID); err != nil {
+		t.Errorf("client token used by its client: err = %v", err)
+	}
+}
+
+func TestRefreshTokenHashedInPlace(t *testing.T) {
+	userID := uuid.New()
+	cfg, fake, _ := newTestConfig(t, userID)
+	tokens := newFakeRefreshTokens(fake)
+
+	// a token from before hashing, as migration 016 left it:
+	// encode(sha256(convert_to(token, 'UTF8')), 'hex')
+	legacy := "3f2a9c0e5b7d4e61a8c2f0b9d6e4a1c7"
+	sum := sha256.Sum256([]byte(legacy))
+	tokens[hex.EncodeToString(sum[:])] = &database.RefreshToken{
+		TokenHash: hex.EncodeToString(sum[:]),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(time.Hour),
+		FamilyID:  uuid.New(),
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+legacy)
+	w := httptest.NewRecorder()
+	cfg.handlerRefresh(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("code = %d, want %d: the session didn't survive the migration", w.Code, http.StatusOK)
+	}
+}
+
+// fakeChirps keeps chirps, their revisions and likes and answers the chirp
+// queries like Postgres would.
+type fakeChirps struct {
+	chirps    map[uuid.UUID]*database.Chirp
+	revisions []database.ChirpRevision
+	// likes holds who liked each chirp
+	likes map[uuid.UUID]map[uuid.UUID]bool
+}
+
+func newFakeChirps(fake *fakeDB) *fakeChirps {
+	f := &fakeChirps{chirps: map[uuid.UUID]*database.Chirp{}, likes: map[uuid.UUID]map[uuid.UUID]bool{}}
+	live := func(id driver.Value) (*database.Chirp, bool) {
+		chirp, ok := f.chirps[fakeUUID(id)]
+		return chirp, ok && !chirp.DeletedAt.Valid
+	}
+	getChirp := func(args []driver.Value) ([][]driver.Value, error) {
+		chirp, ok := live(args[0])
+		if !ok {
+			return nil, nil
+		}
+		return [][]driver.Value{fakeRow(*chirp)}, nil
+	}
+	fake.on("GetChirp", getChirp)
+	fake.on("GetChirpForUpdate", getChirp)
+	fake.on("UpdateChirpBody", func(args []driver.Value) ([][]driver.Value, error) {
+		chirp := f.chirps[fakeUUID(args[1])]
+		chirp.Body = args[0].(string)
+		chirp.UpdatedAt = time.Now()
+		return [][]driver.Value{fakeRow(*chirp)}, nil
+	})
+	fake.on("CreateChirpRevision", func(args []driver.Value) ([][]driver.Value, error) {
+		revision := database.ChirpRevision{
+			ID:         uuid.New(),
+			CreatedAt:  args[0].(time.Time),
+			ReplacedAt: time.Now(),
+			ChirpID:    fakeUUID(args[1]),
+			Body:       args[2].(string),
+		}
+		f.revisions = append(f.revisions, revision)
+		return [][]driver.Value{fakeRow(revision)}, nil
+	})
+	fake.on("GetChirpRevisions", func(args []driver.Value) ([][]driver.Value, error) {
+		rows := [][]driver.Value{}
+		// newest first, and they were added oldest first
+		for _, revision := range slices.Backward(f.revisions) {
+			if revision.ChirpID == fakeUUID(args[0]) {
+				rows = append(rows, fakeRow(revision))
+			}
+		}
+		return rows, nil
+	})
+	fake.on("DeleteChirpRevisions", func(args []driver.Value) ([][]driver.Value, error) {
+		f.revisions = slices.DeleteFunc(f.revisions, func(revision database.ChirpRevision) bool {
+			return revision.ChirpID == fakeUUID(args[0])
+		})
+		return nil, nil
+	})
+	fake.on("ChirpHasDependents", func(args []driver.Value) ([][]driver.Value, error) {
+		id := fakeUUID(args[0])
+		for _, chirp := range f.chirps {
+			plain := chirp.IsPlainRechirp && !chirp.DeletedAt.Valid
+			if chirp.InReplyTo.UUID == id || (chirp.RechirpOf.UUID == id && !plain) {
+				return [][]driver.Value{{true}}, nil
+			}
+		}
+		return [][]driver.Value{{false}}, nil
+	})
+	fake.on("TombstoneChirp", func(args []driver.Value) ([][]driver.Value, error) {
+		chirp := f.chirps[fakeUUID(args[0])]
+		chirp.Body = ""
+		chirp.DeletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		chirp.UpdatedAt = time.Now()
+		return nil, nil
+	})
+	fake.on("DeleteChirp", func(args []driver.Value) ([][]driver.Value, error) {
+		delete(f.chirps, fakeUUID(args[0]))
+		return nil, nil
+	})
+	fake.on("DeletePlainRechirps", func(args []driver.Value) ([][]driver.Value, error) {
+		for id, chirp := range f.chirps {
+			if chirp.RechirpOf.UUID == fakeUUID(args[0]) && chirp.IsPlainRechirp && !chirp.DeletedAt.Valid {
+				delete(f.chirps, id)
+			}
+		}
+		return nil, nil
+	})
+	threadRow := func(chirp *database.Chirp, depth int) []driver.Value {
+		return fakeRow(database.GetChirpAncestorsRow{
+			ID:        chirp.ID,
+			CreatedAt: chirp.CreatedAt,
+			UpdatedAt: chirp.UpdatedAt,
+			Body:      chirp.Body,
+			UserID:    chirp.UserID,
+			InReplyTo: chirp.InReplyTo,
+			DeletedAt: chirp.DeletedAt,
+			Depth:     int32(depth),
+		})
+	}
+	fake.on("GetChirpAncestors", func(args []driver.Value) ([][]driver.Value, error) {
+		// root first
+		rows := [][]driver.Value{}
+		for chirp, ok := f.chirps[fakeUUID(args[0])]; ok; chirp, ok = f.chirps[chirp.InReplyTo.UUID] {
+			rows = slices.Insert(rows, 0, threadRow(chirp, len(rows)))
+		}
+		return rows, nil
+	})
+	fake.on("GetChirpDescendants", func(args []driver.Value) ([][]driver.Value, error) {
+		// by depth, then by age
+		rows := [][]driver.Value{}
+		level := []uuid.UUID{fakeUUID(args[0])}
+		for depth := 1; len(level) > 0; depth++ {
+			next := []*database.Chirp{}
+			for _, chirp := range f.chirps {
+				if chirp.InReplyTo.Valid && slices.Contains(level, chirp.InReplyTo.UUID) {
+					next = append(next, chirp)
+				}
+			}
+			slices.SortFunc(next, func(a, b *database.Chirp) int {
+				return a.CreatedAt.Compare(b.CreatedAt)
+			})
+			level = level[:0]
+			for _, chirp := range next {
+				rows = append(rows, threadRow(chirp, depth))
+				level = append(level, chirp.ID)
+			}
+		}
+		return rows, nil
+	})
+	fake.on("GetChirpsByIDs", func(args []driver.Value) ([][]driver.Value, error) {
+		rows := [][]driver.Value{}
+		for _, id := range fakeUUIDs(args[0]) {
+			if chirp, ok := f.chirps[id]; ok {
+				rows = append(rows, fakeRow(*chirp))
+			}
+		}
+		return rows, nil
+	})
+	fake.on("CreatePlainRechirp", func(args []driver.Value) ([][]driver.Value, error) {
+		userID, rechirpOf := fakeUUID(args[0]), fakeUUID(args[1])
+		// ON CONFLICT DO NOTHING returns no row
+		if f.plainRechirp(userID, rechirpOf) != nil {
+			return nil, nil
+		}
+		chirp := f.add(userID, "", 0)
+		chirp.RechirpOf = uuid.NullUUID{UUID: rechirpOf, Valid: true}
+		chirp.IsPlainRechirp = true
+		return [][]driver.Value{fakeRow(*chirp)}, nil
+	})
+	fake.on("DeletePlainRechirp", func(args []driver.Value) ([][]driver.Value, error) {
+		chirp := f.plainRechirp(fakeUUID(args[0]), fakeUUID(args[1]))
+		if chirp == nil {
+			return nil, nil
+		}
+		delete(f.chirps, chirp.ID)
+		return [][]driver.Value{{}}, nil
+	})
+	fake.on("LikeChirp", func(args []driver.Value) ([][]driver.Value, error) {
+		chirpID := fakeUUID(args[0])
+		if f.likes[chirpID] == nil {
+			f.likes[chirpID] = map[uuid.UUID]bool{}
+		}
+		f.likes[chirpID][fakeUUID(args[1])] = true
+		return nil, nil
+	})
+	fake.on("UnlikeChirp", func(args []driver.Value) ([][]driver.Value, error) {
+		delete(f.likes[fakeUUID(args[0])], fakeUUID(args[1]))
+		return nil, nil
+	})
+	fake.on("GetChirpLikeStats", func(args []driver.Value) ([][]driver.Value, error) {
+		viewerID := fakeUUID(args[0])
+		rows := [][]driver.Value{}
+		for _, chirpID := range fakeUUIDs(args[1]) {
+			// chirps without likes have no row
+			if len(f.likes[chirpID]) == 0 {
+				continue
+			}
+			rows = append(rows, fakeRow(database.GetChirpLikeStatsRow{
+				ChirpID:   chirpID,
+				LikeCount: int64(len(f.likes[chirpID])),
+				LikedByMe: f.likes[chirpID][viewerID],
+			}))
+		}
+		return rows, nil
+	})
+	fake.on("ListModerationRules", func([]driver.Value) ([][]driver.Value, error) {
+		return nil, nil
+	})
+	return f
+}
+
+// plainRechirp returns the live plain rechirp of rechirpOf by userID, if
+// there is one.
+func (f *fakeChirps) plainRechirp(userID, rechirpOf uuid.UUID) *database.Chirp {
+	for _, chirp := range f.chirps {
+		if chirp.UserID == userID && chirp.RechirpOf.UUID == rechirpOf && chirp.IsPlainRechirp && !chirp.DeletedAt.Valid {
+			return chirp
+		}
+	}
+	return nil
+}
+
+// add stores a chirp by userID posted ago.
+func (f *fakeChirps) add(userID uuid.UUID, body string, ago time.Duration) *database.Chirp {
+	at := time.Now().Add(-ago)
+	chirp := &database.Chirp{ID: uuid.New(), CreatedAt: at, UpdatedAt: at, Body: body, UserID: userID}
+	f.chirps[chirp.ID] = chirp
+	return chirp
+}
+
+// newChirpsTestConfig is newTestConfig with the built-in plans, the default
+// moderation and the chirp queries. Every user is on the free plan.
+func newChirpsTestConfig(t *testing.T, userID uuid.UUID) (*apiConfig, *fakeDB, *fakeChirps, string) {
+	t.Helper()
+	cfg, fake, token := newTestConfig(t, userID)
+	cfg.plans = entitlements.Default()
+	cfg.moderator = &moderator{db: cfg.db, words: moderation.DefaultWords}
+	fake.on("GetUserById", func(args []driver.Value) ([][]driver.Value, error) {
+		return [][]driver.Value{fakeRow(database.User{ID: fakeUUID(args[0]), EmailVerifiedAt: sql.NullTime{Time: time.Now(), Valid: true}})}, nil
+	})
+	// every attempt is in the same hour
+	attempts := map[uuid.UUID]int32{}
+	fake.on("AddChirpAttempt", func(args []driver.Value) ([][]driver.Value, error) {
+		userID := fakeUUID(args[0])
+		attempts[userID]++
+		return [][]driver.Value{fakeRow(database.ChirpRateLimit{UserID: userID, WindowStart: time.Now(), Attempts: attempts[userID]})}, nil
+	})
+	return cfg, fake, newFakeChirps(fake), token
+}
+
+// chirpRequest calls handler for the chirp with chirpID as the holder of
+// token, which may be empty.
+func chirpRequest(handler http.HandlerFunc, method string, chirpID uuid.UUID, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/chirps/"+chirpID.String(), strings.NewReader(body))
+	req.SetPathValue("chirpID", chirpID.String())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestChirpsEditRevisions(t *testing.T) {
+	userID := uuid.New()
+	cfg, _, chirps, token := newChirpsTestConfig(t, userID)
+	chirp := chirps.add(userID, "first", time.Minute)
+	// when each body was published
+	publishedAt := []time.Time{chirp.UpdatedAt}
+
+	for _, body := range []string{"second", "third"} {
+		w := chirpRequest(cfg.handlerChirpsUpdate, http.MethodPut, chirp.ID, token, `{"body": "`+body+`"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("edit to %q: code = %d, want %d: %s", body, w.Code, http.StatusOK, w.Body)
+		}
+		edited := Chirp{}
+		if err := json.Unmarshal(w.Body.Bytes(), &edited); err != nil {
+			t.Fatal(err)
+		}
+		if edited.Body != body || !edited.CreatedAt.Equal(chirp.CreatedAt) {
+			t.Errorf("edited chirp = %+v, want body %q and the original created_at", edited, body)
+		}
+		publishedAt = append(publishedAt, edited.UpdatedAt)
+	}
+
+	w := chirpRequest(cfg.handlerChirpRevisionsGet, http.MethodGet, chirp.ID, "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("revisions: code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	revisions := []ChirpRevision{}
+	if err := json.Unmarshal(w.Body.Bytes(), &revisions); err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Body != "second" || revisions[1].Body != "first" {
+		t.Fatalf("revisions = %+v, want second then first", revisions)
+	}
+	if !revisions[1].CreatedAt.Equal(publishedAt[0]) || !revisions[0].CreatedAt.Equal(publishedAt[1]) {
+		t.Errorf("revisions = %+v, want them dated when they were published, %v", revisions, publishedAt[:2])
+	}
+}
+
+func TestChirpsEditRefused(t *testing.T) {
+	userID := uuid.New()
+	tests := []struct {
+		name     string
+		chirp    database.Chirp
+		body     string
+		wantCode int
+	}{
+		{"Someone else's", database.Chirp{UserID: uuid.New(), Body: "theirs"}, "mine now", http.StatusForbidden},
+		{"Past the edit window", database.Chirp{UserID: userID, Body: "old", CreatedAt: time.Now().Add(-time.Hour)}, "new", http.StatusForbidden},
+		{"Plain rechirp", database.Chirp{UserID: userID, IsPlainRechirp: true, RechirpOf: uuid.NullUUID{UUID: uuid.New(), Valid: true}}, "quote", http.StatusBadRequest},
+		{"Empty body", database.Chirp{UserID: userID, Body: "something"}, "", http.StatusBadRequest},
+		{"Deleted", database.Chirp{UserID: userID, DeletedAt: sql.NullTime{Time: time.Now(), Valid: true}}, "back", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, fake, chirps, token := newChirpsTestConfig(t, userID)
+			chirp := tt.chirp
+			chirp.ID = uuid.New()
+			if chirp.CreatedAt.IsZero() {
+				chirp.CreatedAt = time.Now()
+			}
+			chirps.chirps[chirp.ID] = &chirp
+
+			w := chirpRequest(cfg.handlerChirpsUpdate, http.MethodPut, chirp.ID, token, `{"body": "`+tt.body+`"}`)
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if len(chirps.revisions) != 0 || len(fake.called("UpdateChirpBody")) != 0 {
+				t.Error("a refused edit changed the chirp")
+			}
+		})
+	}
+}
+
+func TestChirpsDeleteTombstones(t *testing.T) {
+	userID := uuid.New()
+	cfg, _, chirps, token := newChirpsTestConfig(t, userID)
+
+	alone := chirps.add(userID, "nothing hangs off this", 3*time.Minute)
+	w := chirpRequest(cfg.handlerChirpsDelete, http.MethodDelete, alone.ID, token, "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete: code = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	if _, ok := chirps.chirps[alone.ID]; ok {
+		t.Error("a chirp without replies or quotes was kept")
+	}
+
+	parent := chirps.add(userID, "parent", 2*time.Minute)
+	chirps.revisions = append(chirps.revisions, database.ChirpRevision{ID: uuid.New(), ChirpID: parent.ID, Body: "parnet"})
+	reply := chirps.add(uuid.New(), "reply", time.Minute)
+	reply.InReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	rechirp := chirps.add(uuid.New(), "", time.Minute)
+	rechirp.RechirpOf = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	rechirp.IsPlainRechirp = true
+
+	w = chirpRequest(cfg.handlerChirpsDelete, http.MethodDelete, parent.ID, token, "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete with a reply: code = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	if !parent.DeletedAt.Valid || parent.Body != "" {
+		t.Errorf("chirp with a reply = %+v, want a tombstone without its body", parent)
+	}
+	if len(chirps.revisions) != 0 {
+		t.Error("a tombstone kept its revisions")
+	}
+	if _, ok := chirps.chirps[rechirp.ID]; ok {
+		t.Error("a plain rechirp of a tombstone was kept")
+	}
+
+	// the reply still shows where it belongs
+	w = chirpRequest(cfg.handlerChirpsThread, http.MethodGet, reply.ID, "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("thread: code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	thread := struct {
+		Ancestors []Chirp         `json:"ancestors"`
+		Chirp     chirpThreadNode `json:"chirp"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &thread); err != nil {
+		t.Fatal(err)
+	}
+	if len(thread.Ancestors) != 1 || thread.Ancestors[0].ID != parent.ID || !thread.Ancestors[0].Deleted || thread.Ancestors[0].Body != "" {
+		t.Errorf("ancestors = %+v, want the tombstone", thread.Ancestors)
+	}
+
+	// and the tombstone is gone from everywhere else
+	if w := chirpRequest(cfg.handlerChirpsGet, http.MethodGet, parent.ID, "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("get tombstone: code = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := chirpRequest(cfg.handlerChirpsDelete, http.MethodDelete, parent.ID, token, ""); w.Code != http.StatusNotFound {
+		t.Errorf("delete tombstone: code = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestChirpsThread(t *testing.T) {
+	cfg, _, chirps, _ := newChirpsTestConfig(t, uuid.New())
+	root := chirps.add(uuid.New(), "root", 4*time.Minute)
+	middle := chirps.add(uuid.New(), "middle", 3*time.Minute)
+	middle.InReplyTo = uuid.NullUUID{UUID: root.ID, Valid: true}
+	first := chirps.add(uuid.New(), "first reply", 2*time.Minute)
+	first.InReplyTo = uuid.NullUUID{UUID: middle.ID, Valid: true}
+	second := chirps.add(uuid.New(), "second reply", time.Minute)
+	second.InReplyTo = uuid.NullUUID{UUID: middle.ID, Valid: true}
+	nested := chirps.add(uuid.New(), "nested", 0)
+	nested.InReplyTo = uuid.NullUUID{UUID: first.ID, Valid: true}
+
+	w := chirpRequest(cfg.handlerChirpsThread, http.MethodGet, middle.ID, "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	thread := struct {
+		Ancestors []Chirp         `json:"ancestors"`
+		Chirp     chirpThreadNode `json:"chirp"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &thread); err != nil {
+		t.Fatal(err)
+	}
+	if len(thread.Ancestors) != 1 || thread.Ancestors[0].ID != root.ID {
+		t.Errorf("ancestors = %+v, want the root", thread.Ancestors)
+	}
+	replies := thread.Chirp.Replies
+	if thread.Chirp.ID != middle.ID || len(replies) != 2 || replies[0].ID != first.ID || replies[1].ID != second.ID {
+		t.Fatalf("chirp = %+v, want middle with its two replies in order", thread.Chirp)
+	}
+	if len(replies[0].Replies) != 1 || replies[0].Replies[0].ID != nested.ID || len(replies[1].Replies) != 0 {
+		t.Errorf("replies = %+v, want the nested reply under the first", replies)
+	}
+}
+
+func TestChirpsLikes(t *testing.T) {
+	userID := uuid.New()
+	cfg, _, chirps, token := newChirpsTestConfig(t, userID)
+	_, _, otherToken := newTestConfig(t, uuid.New())
+	chirp := chirps.add(uuid.New(), "likeable", time.Minute)
+	get := func(token string) Chirp {
+		t.Helper()
+		w := chirpRequest(cfg.handlerChirpsGet, http.MethodGet, chirp.ID, token, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("get: code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		got := Chirp{}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	// liking twice counts once
+	for _, token := range []string{token, token, otherToken} {
+		if w := chirpRequest(cfg.handlerChirpsLike, http.MethodPost, chirp.ID, token, ""); w.Code != http.StatusNoContent {
+			t.Fatalf("like: code = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+		}
+	}
+	if got := get(token); got.LikeCount != 2 || !got.LikedByMe {
+		t.Errorf("as a liker: %d likes, liked by me %v, want 2, true", got.LikeCount, got.LikedByMe)
+	}
+	if got := get(""); got.LikeCount != 2 || got.LikedByMe {
+		t.Errorf("anonymous: %d likes, liked by me %v, want 2, false", got.LikeCount, got.LikedByMe)
+	}
+
+	if w := chirpRequest(cfg.handlerChirpsUnlike, http.MethodDelete, chirp.ID, token, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("unlike: code = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	if got := get(token); got.LikeCount != 1 || got.LikedByMe {
+		t.Errorf("after unliking: %d likes, liked by me %v, want 1, false", got.LikeCount, got.LikedByMe)
+	}
+
+	if w := chirpRequest(cfg.handlerChirpsLike, http.MethodPost, uuid.New(), token, ""); w.Code != http.StatusNotFound {
+		t.Errorf("like a missing chirp: code = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestChirpsRechirp(t *testing.T) {
+	userID := uuid.New()
+	cfg, _, chirps, token := newChirpsTestConfig(t, userID)
+	_, _, otherToken := newTestConfig(t, uuid.New())
+	original := chirps.add(uuid.New(), "worth sharing", time.Minute)
+	rechirp := func(chirpID uuid.UUID, token string) *httptest.ResponseRecorder {
+		return chirpRequest(cfg.handlerChirpsRechirp, http.MethodPost, chirpID, token, "")
+	}
+
+	w := rechirp(original.ID, token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("rechirp: code = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	mine := Chirp{}
+	if err := json.Unmarshal(w.Body.Bytes(), &mine); err != nil {
+		t.Fatal(err)
+	}
+	if mine.RechirpOf == nil || *mine.RechirpOf != original.ID || mine.Original == nil || mine.Original.Body != original.Body {
+		t.Errorf("rechirp = %+v, want it to embed the original", mine)
+	}
+
+	// once per user, however the chirp is reached
+	if w := rechirp(original.ID, token); w.Code != http.StatusConflict {
+		t.Errorf("rechirp again: code = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := rechirp(mine.ID, token); w.Code != http.StatusConflict {
+		t.Errorf("rechirp own rechirp: code = %d, want %d", w.Code, http.StatusConflict)
+	}
+	w = rechirp(mine.ID, otherToken)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("rechirp a rechirp: code = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	theirs := Chirp{}
+	if err := json.Unmarshal(w.Body.Bytes(), &theirs); err != nil {
+		t.Fatal(err)
+	}
+	if theirs.RechirpOf == nil || *theirs.RechirpOf != original.ID {
+		t.Errorf("rechirp of a rechirp points at %v, want the original %v", theirs.RechirpOf, original.ID)
+	}
+
+	// a quote of the same chirp is not what undo removes
+	quote := chirps.add(userID, "so true", 0)
+	quote.RechirpOf = uuid.NullUUID{UUID: original.ID, Valid: true}
+	if w := chirpRequest(cfg.handlerChirpsUndoRechirp, http.MethodDelete, original.ID, token, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("undo: code = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	if w := chirpRequest(cfg.handlerChirpsUndoRechirp, http.MethodDelete, original.ID, token, ""); w.Code != http.StatusNotFound {
+		t.Errorf("undo again: code = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if _, ok := chirps.chirps[quote.ID]; !ok {
+		t.Error("undo removed the quote")
+	}
+	if w := rechirp(original.ID, token); w.Code != http.StatusCreated {
+		t.Errorf("rechirp after undoing: code = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+}
+
+func TestChirpsRechirpGates(t *testing.T) {
+	userID := uuid.New()
+
+	cfg, fake, chirps, token := newChirpsTestConfig(t, userID)
+	cfg.requireEmailVerification = true
+	fake.on("GetUserById", func(args []driver.Value) ([][]driver.Value, error) {
+		return [][]driver.Value{fakeRow(database.User{ID: fakeUUID(args[0])})}, nil
+	})
+	original := chirps.add(uuid.New(), "worth sharing", time.Minute)
+	if w := chirpRequest(cfg.handlerChirpsRechirp, http.MethodPost, original.ID, token, ""); w.Code != http.StatusForbidden {
+		t.Errorf("unverified email: code = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	cfg, _, chirps, token = newChirpsTestConfig(t, userID)
+	perHour := cfg.plans.For(entitlements.FreePlan).ChirpsPerHour
+	originals := []uuid.UUID{}
+	for range perHour {
+		originals = append(originals, chirps.add(uuid.New(), "worth sharing", time.Minute).ID)
+	}
+	codes := map[int]int{}
+	for _, id := range originals {
+		codes[chirpRequest(cfg.handlerChirpsRechirp, http.MethodPost, id, token, "").Code]++
+	}
+	extra := chirps.add(uuid.New(), "one too many", 0)
+	w := chirpRequest(cfg.handlerChirpsRechirp, http.MethodPost, extra.ID, token, "")
+	if codes[http.StatusCreated] != perHour || w.Code != http.StatusTooManyRequests {
+		t.Errorf("codes = %v then %d, want %d rechirps then %d", codes, w.Code, perHour, http.StatusTooManyRequests)
+	}
+}
+
+// fakeFollows answers the follow and timeline queries from follows between
+// users and the chirps in chirps.
+type fakeFollows struct {
+	// followedAt holds when each follower started following each followee
+	followedAt map[uuid.UUID]map[uuid.UUID]time.Time
+}
+
+func newFakeFollows(fake *fakeDB, chirps *fakeChirps) *fakeFollows {
+	f := &fakeFollows{followedAt: map[uuid.UUID]map[uuid.UUID]time.Time{}}
+	fake.on("FollowUser", func(args []driver.Value) ([][]driver.Value, error) {
+		follower, followee := fakeUUID(args[0]), fakeUUID(args[1])
+		if f.followedAt[follower] == nil {
+			f.followedAt[follower] = map[uuid.UUID]time.Time{}
+		}
+		if _, ok := f.followedAt[follower][followee]; !ok {
+			f.followedAt[follower][followee] = time.Now()
+		}
+		return nil, nil
+	})
+	fake.on("UnfollowUser", func(args []driver.Value) ([][]driver.Value, error) {
+		delete(f.followedAt[fakeUUID(args[0])], fakeUUID(args[1]))
+		return nil, nil
+	})
+	followList := func(followers bool) fakeQuery {
+		return func(args []driver.Value) ([][]driver.Value, error) {
+			userID := fakeUUID(args[0])
+			rows := []database.GetFollowersRow{}
+			for follower, followees := range f.followedAt {
+				for followee, at := range followees {
+					switch {
+					case followers && followee == userID:
+						rows = append(rows, database.GetFollowersRow{UserID: follower, CreatedAt: at})
+					case !followers && follower == userID:
+						rows = append(rows, database.GetFollowersRow{UserID: followee, CreatedAt: at})
+					}
+				}
+			}
+			return fakePage(rows, args[1:], true, func(row database.GetFollowersRow) (time.Time, uuid.UUID) {
+				return row.CreatedAt, row.UserID
+			}), nil
+		}
+	}
+	fake.on("GetFollowers", followList(true))
+	fake.on("GetFollowing", followList(false))
+	timeline := func(desc bool) fakeQuery {
+		return func(args []driver.Value) ([][]driver.Value, error) {
+			followees := f.followedAt[fakeUUID(args[0])]
+			rows := []database.Chirp{}
+			for _, chirp := range chirps.chirps {
+				if _, ok := followees[chirp.UserID]; ok && !chirp.DeletedAt.Valid {
+					rows = append(rows, *chirp)
+				}
+			}
+			return fakePage(rows, args[1:], desc, func(chirp database.Chirp) (time.Time, uuid.UUID) {
+				return chirp.CreatedAt, chirp.ID
+			}), nil
+		}
+	}
+	fake.on("GetTimelineDesc", timeline(true))
+	fake.on("GetTimelineAsc", timeline(false))
+	return f
+}
+
+// fakePage orders rows by key and keeps those past the cursor, up to the
+// limit, for the keyset pagination queries. args are the cursor's created_at
+// and id, then the limit.
+func fakePage[T any](rows []T, args []driver.Value, desc bool, key func(T) (time.Time, uuid.UUID)) [][]driver.Value {
+	compare := func(a, b T) int {
+		aAt, aID := key(a)
+		bAt, bID := key(b)
+		if c := aAt.Compare(bAt); c != 0 {
+			return c
+		}
+		return strings.Compare(aID.String(), bID.String())
+	}
+	if desc {
+		slices.SortFunc(rows, func(a, b T) int { return compare(b, a) })
+	} else {
+		slices.SortFunc(rows, compare)
+	}
+
+	page := [][]driver.Value{}
+	for _, row := range rows {
+		if cursorAt, ok := args[0].(time.Time); ok {
+			at, id := key(row)
+			c := at.Compare(cursorAt)
+			if c == 0 {
+				c = strings.Compare(id.String(), fakeUUID(args[1]).String())
+			}
+			if (desc && c >= 0) || (!desc && c <= 0) {
+				continue
+			}
+		}
+		if int64(len(page)) == args[2].(int64) {
+			break
+		}
+		page = append(page, fakeRow(row))
+	}
+	return page
+}
+
+// userRequest calls handler for the user with userID as the holder of
+// token, which may be empty.
+func userRequest(handler http.HandlerFunc, method string, userID uuid.UUID, token, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/users/"+userID.String()+"?"+query, nil)
+	req.SetPathValue("userID", userID.String())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestFollows(t *testing.T) {
+	userID, aliceID, bobID := uuid.New(), uuid.New(), uuid.New()
+	cfg, fake, chirps, token := newChirpsTestConfig(t, userID)
+	follows := newFakeFollows(fake, chirps)
+
+	if w := userRequest(cfg.handlerFollow, http.MethodPost, userID, token, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("follow yourself: code = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	for _, id := range []uuid.UUID{aliceID, bobID, aliceID} {
+		if w := userRequest(cfg.handlerFollow, http.MethodPost, id, token, ""); w.Code != http.StatusNoContent {
+			t.Fatalf("follow: code = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+		}
+	}
+	// following alice again kept the first follow; date it well before bob's
+	follows.followedAt[userID][aliceID] = time.Now().Add(-time.Hour)
+
+	list := func(handler http.HandlerFunc, id uuid.UUID, query string) followsPage {
+		t.Helper()
+		w := userRequest(handler, http.MethodGet, id, "", query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		page := followsPage{}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+	ids := func(follows []Follow) []uuid.UUID {
+		ids := []uuid.UUID{}
+		for _, follow := range follows {
+			ids = append(ids, follow.UserID)
+		}
+		return ids
+	}
+
+	page := list(cfg.handlerFollowingGet, userID, "limit=1")
+	if !slices.Equal(ids(page.Users), []uuid.UUID{bobID}) || page.NextCursor == "" {
+		t.Errorf("following = %v, next %q, want bob and a next cursor", ids(page.Users), page.NextCursor)
+	}
+	page = list(cfg.handlerFollowingGet, userID, "limit=1&cursor="+page.NextCursor)
+	if !slices.Equal(ids(page.Users), []uuid.UUID{aliceID}) || page.NextCursor != "" {
+		t.Errorf("following page 2 = %v, next %q, want alice and no cursor", ids(page.Users), page.NextCursor)
+	}
+	if page := list(cfg.handlerFollowersGet, aliceID, ""); !slices.Equal(ids(page.Users), []uuid.UUID{userID}) {
+		t.Errorf("alice's followers = %v, want the user", ids(page.Users))
+	}
+	if page := list(cfg.handlerFollowersGet, userID, ""); len(page.Users) != 0 {
+		t.Errorf("the user's followers = %v, want none", ids(page.Users))
+	}
+
+	if w := userRequest(cfg.handlerUnfollow, http.MethodDelete, bobID, token, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("unfollow: code = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	if page := list(cfg.handlerFollowersGet, bobID, ""); len(page.Users) != 0 {
+		t.Errorf("bob's followers after the unfollow = %v, want none", ids(page.Users))
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	userID, aliceID, bobID := uuid.New(), uuid.New(), uuid.New()
+	cfg, fake, chirps, token := newChirpsTestConfig(t, userID)
+	fake.on("GetChirpLikeStats", func([]driver.Value) ([][]driver.Value, error) {
+		return nil, nil
+	})
+	newFakeFollows(fake, chirps)
+	for _, id := range []uuid.UUID{aliceID, bobID} {
+		if w := userRequest(cfg.handlerFollow, http.MethodPost, id, token, ""); w.Code != http.StatusNoContent {
+			t.Fatalf("follow: code = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+		}
+	}
+	chirps.add(aliceID, "alice 1", 4*time.Minute)
+	chirps.add(bobID, "bob 1", 3*time.Minute)
+	chirps.add(uuid.New(), "a stranger", 2*time.Minute)
+	chirps.add(userID, "my own", 2*time.Minute)
+	chirps.add(aliceID, "deleted", 90*time.Second).DeletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	chirps.add(aliceID, "alice 2", time.Minute)
+
+	timeline := func(query string) chirpsPage {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/timeline?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.handlerTimeline(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		page := chirpsPage{}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+	bodies := func(page chirpsPage) []string {
+		bodies := []string{}
+		for _, chirp := range page.Chirps {
+			bodies = append(bodies, chirp.Body)
+		}
+		return bodies
+	}
+
+	first := timeline("limit=2")
+	if got, want := bodies(first), []string{"alice 2", "bob 1"}; !slices.Equal(got, want) || first.NextCursor == "" || first.PrevCursor != "" {
+		t.Fatalf("first page = %q, next %q, prev %q, want %q and only a next cursor", got, first.NextCursor, first.PrevCursor, want)
+	}
+	second := timeline("limit=2&cursor=" + first.NextCursor)
+	if got, want := bodies(second), []string{"alice 1"}; !slices.Equal(got, want) || second.NextCursor != "" || second.PrevCursor == "" {
+		t.Fatalf("second page = %q, next %q, prev %q, want %q and only a prev cursor", got, second.NextCursor, second.PrevCursor, want)
+	}
+	back := timeline("limit=2&cursor=" + second.PrevCursor)
+	if got, want := bodies(back), bodies(first); !slices.Equal(got, want) {
+		t.Errorf("back to the first page = %q, want %q", got, want)
+	}
+
+	if w := userRequest(cfg.handlerUnfollow, http.MethodDelete, aliceID, token, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("unfollow: code = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	if got, want := bodies(timeline("")), []string{"bob 1"}; !slices.Equal(got, want) {
+		t.Errorf("after unfollowing alice = %q, want %q", got, want)
+	}
+}
+
+// fakeUsers answers the user and profile queries, keeping handles unique.
+type fakeUsers map[uuid.UUID]*database.User
+
+func newFakeUsers(fake *fakeDB, users ...database.User) fakeUsers {
+	f := fakeUsers{}
+	for _, user := range users {
+		f[user.ID] = &user
+	}
+	fake.on("GetUserById", func(args []driver.Value) ([][]driver.Value, error) {
+		user, ok := f[fakeUUID(args[0])]
+		if !ok {
+			return nil, nil
+		}
+		return [][]driver.Value{fakeRow(*user)}, nil
+	})
+	fake.on("GetUserByHandle", func(args []driver.Value) ([][]driver.Value, error) {
+		for _, user := range f {
+			if user.Handle.Valid && user.Handle.String == args[0] {
+				return [][]driver.Value{fakeRow(*user)}, nil
+			}
+		}
+		return nil, nil
+	})
+	fake.on("UpdateUserProfile", func(args []driver.Value) ([][]driver.Value, error) {
+		handle, _ := args[0].(string)
+		id := fakeUUID(args[4])
+		for _, other := range f {
+			if other.ID != id && other.Handle.Valid && other.Handle.String == handle {
+				return nil, &pq.Error{Code: "23505"}
+			}
+		}
+		user := f[id]
+		user.Handle = sql.NullString{String: handle, Valid: args[0] != nil}
+		user.DisplayName = args[1].(string)
+		user.Bio = args[2].(string)
+		user.AvatarUrl = args[3].(string)
+		return [][]driver.Value{fakeRow(*user)}, nil
+	})
+	return f
+}
+
+func TestUsersProfileGet(t *testing.T) {
+	user := database.User{
+		ID:          uuid.New(),
+		Email:       "alice@example.com",
+		Handle:      sql.NullString{String: "alice", Valid: true},
+		DisplayName: "Alice",
+		Bio:         "hello",
+		AvatarUrl:   "https://example.com/alice.png",
+	}
+	cfg, fake, _ := newTestConfig(t, uuid.New())
+	cfg.plans = entitlements.Default()
+	newFakeUsers(fake, user)
+
+	get := func(handle string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/users/"+handle, nil)
+		req.SetPathValue("handle", handle)
+		w := httptest.NewRecorder()
+		cfg.handlerUsersProfileGet(w, req)
+		return w
+	}
+
+	w := get("Alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if strings.Contains(w.Body.String(), user.Email) || strings.Contains(w.Body.String(), `"email"`) {
+		t.Errorf("profile leaks the email: %s", w.Body)
+	}
+	profile := Profile{}
+	if err := json.Unmarshal(w.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	want := Profile{ID: user.ID, Handle: "alice", DisplayName: "Alice", Bio: "hello", AvatarURL: user.AvatarUrl}
+	if profile.ID != want.ID || profile.Handle != want.Handle || profile.DisplayName != want.DisplayName || profile.Bio != want.Bio || profile.AvatarURL != want.AvatarURL {
+		t.Errorf("profile = %+v, want %+v", profile, want)
+	}
+
+	if w := get("nobody"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown handle: code = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestUsersUpdateProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		wantCode int
+		want     database.User
+	}{
+		{"Bio only", http.MethodPut, `{"bio": "new bio"}`, http.StatusOK,
+			database.User{Handle: sql.NullString{String: "alice", Valid: true}, DisplayName: "Alice", Bio: "new bio", AvatarUrl: "https://example.com/a.png"}},
+		{"Handle is lowercased", http.MethodPut, `{"handle": "Alice_2", "display_name": "A"}`, http.StatusOK,
+			database.User{Handle: sql.NullString{String: "alice_2", Valid: true}, DisplayName: "A", Bio: "old bio", AvatarUrl: "https://example.com/a.png"}},
+		{"Avatar cleared", http.MethodPut, `{"avatar_url": ""}`, http.StatusOK,
+			database.User{Handle: sql.NullString{String: "alice", Valid: true}, DisplayName: "Alice", Bio: "old bio"}},
+		{"Handle removed by a merge patch", http.MethodPatch, `{"handle": null}`, http.StatusOK,
+			database.User{DisplayName: "Alice", Bio: "old bio", AvatarUrl: "https://example.com/a.png"}},
+		{"Handle taken", http.MethodPut, `{"handle": "bob"}`, http.StatusConflict, database.User{}},
+		{"Invalid handle", http.MethodPut, `{"handle": "a b"}`, http.StatusBadRequest, database.User{}},
+		{"Bio too long", http.MethodPatch, `{"bio": "` + strings.Repeat("a", maxBioLength+1) + `"}`, http.StatusBadRequest, database.User{}},
+		{"Avatar not a web URL", http.MethodPut, `{"avatar_url": "javascript:alert(1)"}`, http.StatusBadRequest, database.User{}},
+		{"Nothing", http.MethodPut, `{}`, http.StatusBadRequest, database.User{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice := database.User{
+				ID:          uuid.New(),
+				Email:       "alice@example.com",
+				Handle:      sql.NullString{String: "alice", Valid: true},
+				DisplayName: "Alice",
+				Bio:         "old bio",
+				AvatarUrl:   "https://example.com/a.png",
+			}
+			bob := database.User{ID: uuid.New(), Email: "bob@example.com", Handle: sql.NullString{String: "bob", Valid: true}}
+			cfg, fake, token := newTestConfig(t, alice.ID)
+			users := newFakeUsers(fake, alice, bob)
+
+			req := httptest.NewRequest(tt.method, "/api/users", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			if tt.method == http.MethodPatch {
+				cfg.handlerUsersPatch(w, req)
+			} else {
+				cfg.handlerUsersUpdate(w, req)
+			}
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				if len(fake.called("COMMIT")) != 0 {
+					t.Error("a refused update was committed")
+				}
+				return
+			}
+			got := users[alice.ID]
+			if got.Handle != tt.want.Handle || got.DisplayName != tt.want.DisplayName || got.Bio != tt.want.Bio || got.AvatarUrl != tt.want.AvatarUrl {
+				t.Errorf("profile = %q %q %q %q, want %q %q %q %q",
+					got.Handle.String, got.DisplayName, got.Bio, got.AvatarUrl,
+					tt.want.Handle.String, tt.want.DisplayName, tt.want.Bio, tt.want.AvatarUrl)
+			}
+			if got.Email != alice.Email || len(fake.called("UpdateUserEmail")) != 0 || len(fake.called("UpdateUserPassword")) != 0 {
+				t.Error("a profile update touched the credentials")
+			}
+			if strings.Contains(w.Body.String(), "hashed_password") {
+				t.Errorf("response leaks the password hash: %s", w.Body)
+			}
+		})
+	}
+}
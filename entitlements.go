@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+	"Chirpy/internal/entitlements"
+
+	"github.com/google/uuid"
+)
+
+// loadPlans reads plan entitlements from ENTITLEMENTS_FILE if it's set.
+func loadPlans() (*entitlements.Catalog, error) {
+	path := os.Getenv("ENTITLEMENTS_FILE")
+	if path == "" {
+		return entitlements.Default(), nil
+	}
+	return entitlements.LoadFile(path)
+}
+
+// planOf returns the plan a user is on.
+func (cfg *apiConfig) planOf(ctx context.Context, user database.User) (string, error) {
+	if !user.IsChirpyRed {
+		return entitlements.FreePlan, nil
+	}
+	subscription, err := cfg.db.GetSubscription(ctx, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entitlements.RedPlan, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return subscription.Plan, nil
+}
+
+// entitlementsOf returns what a user's plan allows.
+func (cfg *apiConfig) entitlementsOf(ctx context.Context, userID uuid.UUID) (entitlements.Entitlements, error) {
+	user, err := cfg.db.GetUserById(ctx, userID)
+	if err != nil {
+		return entitlements.Entitlements{}, err
+	}
+	plan, err := cfg.planOf(ctx, user)
+	if err != nil {
+		return entitlements.Entitlements{}, err
+	}
+	return cfg.plans.For(plan), nil
+}
+
+// checkChirpRate counts a posting attempt, then responds with 429 and
+// returns false if the user has already made as many in the current hour
+// as their plan allows. Attempts are counted on their own, so deleting a
+// chirp doesn't free a slot, held chirps count, and concurrent posts can't
+// all slip under the limit.
+func (cfg *apiConfig) checkChirpRate(w http.ResponseWriter, r *http.Request, userID uuid.UUID, ent entitlements.Entitlements) bool {
+	if ent.ChirpsPerHour == 0 {
+		return true
+	}
+	now := time.Now().UTC()
+	window, err := cfg.db.AddChirpAttempt(r.Context(), database.AddChirpAttemptParams{
+		UserID: userID,
+		At:     now,
+		Since:  now.Add(-time.Hour),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check chirp limit", err)
+		return false
+	}
+	if int(window.Attempts) <= ent.ChirpsPerHour {
+		return true
+	}
+	// the count starts over when the hour of the window is up
+	wait := max(window.WindowStart.Add(time.Hour).Sub(now), time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "You've reached your hourly chirp limit", nil)
+	return false
+}
+
+// handlerEntitlementsGet tells the caller their plan and its limits.
+func (cfg *apiConfig) handlerEntitlementsGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Plan         string                    `json:"plan"`
+		Entitlements entitlements.Entitlements `json:"entitlements"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeChirpsRead)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	plan, err := cfg.planOf(r.Context(), user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Plan:         plan,
+		Entitlements: cfg.plans.For(plan),
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// fakeDB is a database/sql driver for handler tests. Queries are answered
+// by functions registered under their sqlc name, so tests can check what a
+// handler does without a Postgres server. Queries without a function fail.
+type fakeDB struct {
+	mu      sync.Mutex
+	queries map[string]fakeQuery
+	calls   []fakeCall
+}
+
+// fakeQuery returns the rows of a query, or for an exec as many rows as it
+// affected.
+type fakeQuery func(args []driver.Value) ([][]driver.Value, error)
+
+type fakeCall struct {
+	Name string
+	Args []driver.Value
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("chirpyfake", fakeDriver{})
+}
+
+// newFakeDB returns a fake database and the queries and connection that
+// use it.
+func newFakeDB(t *testing.T) (*fakeDB, *database.Queries, *sql.DB) {
+	t.Helper()
+	f := &fakeDB{queries: map[string]fakeQuery{}}
+	dsn := uuid.NewString()
+	fakeDBsMu.Lock()
+	fakeDBs[dsn] = f
+	fakeDBsMu.Unlock()
+
+	conn, err := sql.Open("chirpyfake", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		fakeDBsMu.Lock()
+		delete(fakeDBs, dsn)
+		fakeDBsMu.Unlock()
+	})
+	return f, database.New(conn), conn
+}
+
+// on answers the query called name with fn.
+func (f *fakeDB) on(name string, fn fakeQuery) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries[name] = fn
+}
+
+// called returns the calls of the query called name, in order.
+func (f *fakeDB) called(name string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []fakeCall
+	for _, c := range f.calls {
+		if c.Name == name {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+var queryNameRe = regexp.MustCompile(`^-- name: (\w+)`)
+
+func (f *fakeDB) run(query string, args []driver.Value) ([][]driver.Value, error) {
+	name := query
+	if m := queryNameRe.FindStringSubmatch(query); m != nil {
+		name = m[1]
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, fakeCall{Name: name, Args: args})
+	fn, ok := f.queries[name]
+	f.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("fakedb: unexpected query %s", name)
+	}
+	return fn(args)
+}
+
+// fakeRow turns a sqlc model or row struct into a result row. Its fields
+// are in column order.
+func fakeRow(v any) []driver.Value {
+	rv := reflect.ValueOf(v)
+	row := make([]driver.Value, 0, rv.NumField())
+	for i := range rv.NumField() {
+		row = append(row, fakeValue(rv.Field(i).Interface()))
+	}
+	return row
+}
+
+func fakeValue(v any) driver.Value {
+	switch v := v.(type) {
+	case []string:
+		value, _ := pq.StringArray(v).Value()
+		return value
+	case json.RawMessage:
+		return []byte(v)
+	case int32:
+		return int64(v)
+	case driver.Valuer:
+		value, _ := v.Value()
+		return value
+	}
+	return v
+}
+
+// fakeUUID reads a UUID argument, or uuid.Nil for NULL.
+func fakeUUID(v driver.Value) uuid.UUID {
+	s, _ := v.(string)
+	id, _ := uuid.Parse(s)
+	return id
+}
+
+// fakeUUIDs reads a uuid[] argument.
+func fakeUUIDs(v driver.Value) []uuid.UUID {
+	strs := pq.StringArray{}
+	strs.Scan(v)
+	ids := make([]uuid.UUID, 0, len(strs))
+	for _, s := range strs {
+		ids = append(ids, fakeUUID(s))
+	}
+	return ids
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	f, ok := fakeDBs[dsn]
+	if !ok {
+		return nil, errors.New("fakedb: closed")
+	}
+	return &fakeConn{db: f}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return &fakeTx{db: c.db}, nil
+}
+
+// fakeTx records COMMIT, so tests can tell whether a transaction went
+// through. Rolled back statements still show up in calls.
+type fakeTx struct {
+	db *fakeDB
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.calls = append(tx.db.calls, fakeCall{Name: "COMMIT"})
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	rows, err := s.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows)), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+	next int
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
@@ -9,4 +9,9 @@ require (
 	golang.org/x/crypto v0.42.0
 )
 
-require github.com/golang-jwt/jwt/v5 v5.3.0
+require (
+	github.com/golang-jwt/jwt/v5 v5.3.0
+	golang.org/x/text v0.29.0
+)
+
+require golang.org/x/sys v0.36.0 // indirect
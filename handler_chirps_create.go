@@ -4,36 +4,76 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"strings"
 	"time"
 
 	"Chirpy/internal/auth"
 	"Chirpy/internal/database"
+	"Chirpy/internal/moderation"
 
 	"github.com/google/uuid"
 )
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	UserID    uuid.UUID `json:"user_id"`
-	Body      string    `json:"body"`
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	UserID    uuid.UUID  `json:"user_id"`
+	Body      string     `json:"body"`
+	InReplyTo *uuid.UUID `json:"in_reply_to,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
+	LikeCount int64      `json:"like_count"`
+	LikedByMe bool       `json:"liked_by_me"`
+	RechirpOf *uuid.UUID `json:"rechirp_of,omitempty"`
+	Original  *Chirp     `json:"original,omitempty"`
+}
+
+func databaseChirpToChirp(dbChirp database.Chirp) Chirp {
+	return Chirp{
+		ID:        dbChirp.ID,
+		CreatedAt: dbChirp.CreatedAt,
+		UpdatedAt: dbChirp.UpdatedAt,
+		UserID:    dbChirp.UserID,
+		Body:      dbChirp.Body,
+		InReplyTo: uuidPtr(dbChirp.InReplyTo),
+		Deleted:   dbChirp.DeletedAt.Valid,
+		RechirpOf: uuidPtr(dbChirp.RechirpOf),
+	}
+}
+
+func uuidPtr(id uuid.NullUUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+	return &id.UUID
 }
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string     `json:"body"`
+		InReplyTo *uuid.UUID `json:"in_reply_to"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	err := cfg.checkEmailVerified(r.Context(), userID)
+	if errors.Is(err, errEmailNotVerified) {
+		respondWithError(w, http.StatusForbidden, err.Error(), err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+
+	ent, err := cfg.entitlementsOf(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if !cfg.checkChirpRate(w, r, userID, ent) {
 		return
 	}
 
@@ -45,53 +85,45 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	cleaned, err := validateChirp(params.Body)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+	verdict, ok := cfg.moderateChirp(w, r, params.Body, ent.MaxChirpLength)
+	if !ok {
+		return
+	}
+
+	inReplyTo := uuid.NullUUID{}
+	if params.InReplyTo != nil {
+		parent, err := cfg.db.GetChirp(r.Context(), *params.InReplyTo)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't find chirp to reply to", err)
+			return
+		}
+		inReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	}
+
+	if verdict.Action == moderation.Hold {
+		held, err := cfg.db.CreateHeldChirp(r.Context(), database.CreateHeldChirpParams{
+			UserID:    userID,
+			Body:      verdict.Text,
+			InReplyTo: inReplyTo,
+			Reasons:   verdict.Reasons,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hold chirp for review", err)
+			return
+		}
+		respondWithHeld(w, held)
 		return
 	}
 
 	chirp, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:   cleaned,
-		UserID: userID,
+		Body:      verdict.Text,
+		UserID:    userID,
+		InReplyTo: inReplyTo,
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
-	})
-}
-
-func validateChirp(body string) (string, error) {
-	const maxChirpLength = 140
-	if len(body) > maxChirpLength {
-		return "", errors.New("Chirp is too long")
-	}
-
-	badWords := map[string]struct{}{
-		"kerfuffle": {},
-		"sharbert":  {},
-		"fornax":    {},
-	}
-	cleaned := getCleanedBody(body, badWords)
-	return cleaned, nil
-}
-
-func getCleanedBody(body string, badWords map[string]struct{}) string {
-	words := strings.Split(body, " ")
-	for i, word := range words {
-		loweredWord := strings.ToLower(word)
-		if _, ok := badWords[loweredWord]; ok {
-			words[i] = "****"
-		}
-	}
-	cleaned := strings.Join(words, " ")
-	return cleaned
+	respondWithJSON(w, http.StatusCreated, databaseChirpToChirp(chirp))
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"Chirpy/internal/auth"
+	"database/sql"
+	"errors"
 	"net/http"
 
 	//"Chirpy/internal/database"
@@ -17,32 +19,62 @@ func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+	userID, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
 		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
 
-	dbChirp, err := cfg.db.GetChirp(r.Context(), chirpID)
-	if err != nil {
+	// the row lock keeps new replies out until we've decided how to delete
+	dbChirp, err := qtx.GetChirpForUpdate(r.Context(), chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
 		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
 		return
 	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
 	if dbChirp.UserID != userID {
 		respondWithError(w, http.StatusForbidden, "You can't delete this chirp", err)
 		return
 	}
 
-	err = cfg.db.DeleteChirp(r.Context(), chirpID)
+	hasDependents, err := qtx.ChirpHasDependents(r.Context(), chirpID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
 		return
 	}
 
+	if hasDependents {
+		// keep a tombstone so replies and quotes stay attached to it,
+		// while plain rechirps have nothing left to show
+		err = qtx.TombstoneChirp(r.Context(), chirpID)
+		if err == nil {
+			err = qtx.DeleteChirpRevisions(r.Context(), chirpID)
+		}
+		if err == nil {
+			err = qtx.DeletePlainRechirps(r.Context(), uuid.NullUUID{UUID: chirpID, Valid: true})
+		}
+	} else {
+		err = qtx.DeleteChirp(r.Context(), chirpID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp", err)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
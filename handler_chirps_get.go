@@ -2,8 +2,10 @@ package main
 
 import (
 	"Chirpy/internal/database"
-	"fmt"
+	"context"
+	"database/sql"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -22,69 +24,128 @@ func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:        dbChirp.ID,
-		CreatedAt: dbChirp.CreatedAt,
-		UpdatedAt: dbChirp.UpdatedAt,
-		UserID:    dbChirp.UserID,
-		Body:      dbChirp.Body,
-	})
+	chirp := databaseChirpToChirp(dbChirp)
+	err = cfg.hydrateChirps(r.Context(), cfg.viewerID(r), &chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirp)
 }
 
 func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
 
-	authId := r.URL.Query().Get("author_id")
+	authorID := uuid.NullUUID{}
+	if authId := query.Get("author_id"); authId != "" {
+		id, err := uuid.Parse(authId)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID", err)
+			return
+		}
+		authorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
 
-	sType := r.URL.Query().Get("sort")
+	sType := query.Get("sort")
 	if sType != "asc" && sType != "desc" {
 		sType = "asc"
 	}
-	
-	fmt.Printf("==>> sType: [%s]\n", sType)
 
-	var dbChirps []database.Chirp
-	var err error
+	page, err := parsePageParams(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// following a prev cursor scans against the requested order
+	desc := (sType == "desc") != page.backward()
+	dbChirps, err := cfg.listChirps(r.Context(), authorID, desc, page)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps", err)
+		return
+	}
+
+	dbChirps, next, prev := paginate(dbChirps, page, func(c database.Chirp) (time.Time, uuid.UUID) {
+		return c.CreatedAt, c.ID
+	})
 
-	if authId != "" {
-		authorId := uuid.MustParse(authId)
-		dbChirps, err = cfg.db.GetChirpsByAuthor(r.Context(), authorId)
-	} else {
-		dbChirps, err = cfg.db.GetChirps(r.Context())
+	chirps := make([]Chirp, 0, len(dbChirps))
+	targets := make([]*Chirp, 0, len(dbChirps))
+	for i, dbChirp := range dbChirps {
+		chirps = append(chirps, databaseChirpToChirp(dbChirp))
+		targets = append(targets, &chirps[i])
 	}
+	err = cfg.hydrateChirps(r.Context(), cfg.viewerID(r), targets...)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps", err)
 		return
-	}	
-
-	chirps := []Chirp{}
-	for _, dbChirp := range dbChirps {
-		if sType == "desc" {
-			chirps = append([]Chirp{{
-				ID:        dbChirp.ID,
-				CreatedAt: dbChirp.CreatedAt,
-				UpdatedAt: dbChirp.UpdatedAt,
-				UserID:    dbChirp.UserID,
-				Body:      dbChirp.Body,
-			}}, chirps...)
-		} else {
-			chirps = append(chirps, Chirp{
-				ID:        dbChirp.ID,
-				CreatedAt: dbChirp.CreatedAt,
-				UpdatedAt: dbChirp.UpdatedAt,
-				UserID:    dbChirp.UserID,
-				Body:      dbChirp.Body,
-			})
+	}
+
+	setLinkHeader(w, r, next, prev)
+	respondWithJSON(w, http.StatusOK, chirpsPage{
+		Chirps:     chirps,
+		NextCursor: next,
+		PrevCursor: prev,
+	})
+}
+
+// hydrateChirps embeds the original of every rechirp and quote and fills in
+// like counts, for the chirps and their originals alike.
+func (cfg *apiConfig) hydrateChirps(ctx context.Context, viewerID uuid.NullUUID, chirps ...*Chirp) error {
+	originalIDs := []uuid.UUID{}
+	for _, chirp := range chirps {
+		if chirp.RechirpOf != nil {
+			originalIDs = append(originalIDs, *chirp.RechirpOf)
+		}
+	}
+
+	likeTargets := append([]*Chirp{}, chirps...)
+	if len(originalIDs) > 0 {
+		dbOriginals, err := cfg.db.GetChirpsByIDs(ctx, originalIDs)
+		if err != nil {
+			return err
+		}
+		originals := make(map[uuid.UUID]Chirp, len(dbOriginals))
+		for _, dbOriginal := range dbOriginals {
+			originals[dbOriginal.ID] = databaseChirpToChirp(dbOriginal)
+		}
+		for _, chirp := range chirps {
+			if chirp.RechirpOf == nil {
+				continue
+			}
+			if original, ok := originals[*chirp.RechirpOf]; ok {
+				chirp.Original = &original
+				likeTargets = append(likeTargets, chirp.Original)
+			}
 		}
-	
-		// chirps = append(chirps, Chirp{
-		// 	ID:        dbChirp.ID,
-		// 	CreatedAt: dbChirp.CreatedAt,
-		// 	UpdatedAt: dbChirp.UpdatedAt,
-		// 	UserID:    dbChirp.UserID,
-		// 	Body:      dbChirp.Body,
-		// })
 	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
+	return cfg.attachLikes(ctx, viewerID, likeTargets...)
 }
 
+// listChirps fetches one more row than the page limit so that paginate can
+// tell whether another page follows.
+func (cfg *apiConfig) listChirps(ctx context.Context, authorID uuid.NullUUID, desc bool, page pageParams) ([]database.Chirp, error) {
+	cursorCreatedAt := sql.NullTime{}
+	cursorID := uuid.NullUUID{}
+	if page.Cursor != nil {
+		cursorCreatedAt = sql.NullTime{Time: page.Cursor.CreatedAt, Valid: true}
+		cursorID = uuid.NullUUID{UUID: page.Cursor.ID, Valid: true}
+	}
+
+	if desc {
+		return cfg.db.ListChirpsDesc(ctx, database.ListChirpsDescParams{
+			AuthorID:        authorID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			Limit:           int32(page.Limit + 1),
+		})
+	}
+	return cfg.db.ListChirpsAsc(ctx, database.ListChirpsAscParams{
+		AuthorID:        authorID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		Limit:           int32(page.Limit + 1),
+	})
+}
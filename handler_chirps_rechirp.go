@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+	"Chirpy/internal/moderation"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerChirpsRechirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	// a rechirp puts a chirp in front of followers, so it's gated like one
+	err = cfg.checkEmailVerified(r.Context(), userID)
+	if errors.Is(err, errEmailNotVerified) {
+		respondWithError(w, http.StatusForbidden, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	ent, err := cfg.entitlementsOf(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if !cfg.checkChirpRate(w, r, userID, ent) {
+		return
+	}
+
+	originalID, err := cfg.rechirpTarget(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+
+	rechirp, err := cfg.db.CreatePlainRechirp(r.Context(), database.CreatePlainRechirpParams{
+		UserID:    userID,
+		RechirpOf: uuid.NullUUID{UUID: originalID, Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "Chirp already rechirped", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rechirp", err)
+		return
+	}
+
+	chirp := databaseChirpToChirp(rechirp)
+	err = cfg.hydrateChirps(r.Context(), uuid.NullUUID{UUID: userID, Valid: true}, &chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, chirp)
+}
+
+func (cfg *apiConfig) handlerChirpsUndoRechirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.db.DeletePlainRechirp(r.Context(), database.DeletePlainRechirpParams{
+		UserID:    userID,
+		RechirpOf: uuid.NullUUID{UUID: chirpID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't undo rechirp", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Chirp wasn't rechirped", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerChirpsQuote(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	err = cfg.checkEmailVerified(r.Context(), userID)
+	if errors.Is(err, errEmailNotVerified) {
+		respondWithError(w, http.StatusForbidden, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	ent, err := cfg.entitlementsOf(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if !cfg.checkChirpRate(w, r, userID, ent) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	verdict, ok := cfg.moderateChirp(w, r, params.Body, ent.MaxChirpLength)
+	if !ok {
+		return
+	}
+	if verdict.Text == "" {
+		respondWithError(w, http.StatusBadRequest, "Quote can't be empty", nil)
+		return
+	}
+
+	originalID, err := cfg.rechirpTarget(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+	rechirpOf := uuid.NullUUID{UUID: originalID, Valid: true}
+
+	if verdict.Action == moderation.Hold {
+		held, err := cfg.db.CreateHeldChirp(r.Context(), database.CreateHeldChirpParams{
+			UserID:    userID,
+			Body:      verdict.Text,
+			RechirpOf: rechirpOf,
+			Reasons:   verdict.Reasons,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hold quote for review", err)
+			return
+		}
+		respondWithHeld(w, held)
+		return
+	}
+
+	quote, err := cfg.db.CreateQuoteChirp(r.Context(), database.CreateQuoteChirpParams{
+		Body:      verdict.Text,
+		UserID:    userID,
+		RechirpOf: rechirpOf,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create quote", err)
+		return
+	}
+
+	chirp := databaseChirpToChirp(quote)
+	err = cfg.hydrateChirps(r.Context(), uuid.NullUUID{UUID: userID, Valid: true}, &chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, chirp)
+}
+
+// rechirpTarget resolves the chirp that a rechirp or quote should point at.
+// Re-sharing a plain rechirp re-shares its original instead.
+func (cfg *apiConfig) rechirpTarget(ctx context.Context, chirpID uuid.UUID) (uuid.UUID, error) {
+	chirp, err := cfg.db.GetChirp(ctx, chirpID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if chirp.IsPlainRechirp {
+		return chirp.RechirpOf.UUID, nil
+	}
+	return chirp.ID, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// chirpSearchResult carries, with ?highlight=true, the body as HTML with
+// the matches in <mark> tags. The body is escaped, so the snippet can be
+// inserted as is.
+type chirpSearchResult struct {
+	Chirp
+	Snippet string `json:"snippet,omitempty"`
+}
+
+func (cfg *apiConfig) handlerChirpsSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	tsQuery, err := buildTSQuery(query.Get("q"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	authorID := uuid.NullUUID{}
+	if authId := query.Get("author_id"); authId != "" {
+		id, err := uuid.Parse(authId)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID", err)
+			return
+		}
+		authorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	limit, err := parsePageLimit(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	highlight := query.Get("highlight") == "true"
+
+	rows, err := cfg.db.SearchChirps(r.Context(), database.SearchChirpsParams{
+		Query:     tsQuery,
+		AuthorID:  authorID,
+		Limit:     int32(limit),
+		Highlight: highlight,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps", err)
+		return
+	}
+
+	results := make([]chirpSearchResult, 0, len(rows))
+	for _, row := range rows {
+		results = append(results, chirpSearchResult{
+			Chirp: Chirp{
+				ID:        row.ID,
+				CreatedAt: row.CreatedAt,
+				UpdatedAt: row.UpdatedAt,
+				UserID:    row.UserID,
+				Body:      row.Body,
+				InReplyTo: uuidPtr(row.InReplyTo),
+				RechirpOf: uuidPtr(row.RechirpOf),
+			},
+			Snippet: row.Snippet,
+		})
+	}
+
+	targets := make([]*Chirp, 0, len(results))
+	for i := range results {
+		targets = append(targets, &results[i].Chirp)
+	}
+	err = cfg.hydrateChirps(r.Context(), cfg.viewerID(r), targets...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+// buildTSQuery turns a user search string into a to_tsquery expression.
+// Plain words are ANDed together, "quoted phrases" must appear in order and
+// a trailing * turns a word into a prefix match (chirp* matches chirpy).
+// Everything but letters and digits is dropped so the result is always a
+// valid tsquery. Punctuation inside a word splits it into a phrase, the way
+// Postgres splits the body, so e-mail and a&b match those words in order.
+func buildTSQuery(q string) (string, error) {
+	terms := []string{}
+	for i, part := range strings.Split(q, `"`) {
+		inPhrase := i%2 == 1
+		if inPhrase {
+			if term := tsQueryTerm(part); term != "" {
+				terms = append(terms, "("+term+")")
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			if term := tsQueryTerm(word); term != "" {
+				terms = append(terms, term)
+			}
+		}
+	}
+	if len(terms) == 0 {
+		return "", errors.New("Search query is empty")
+	}
+	return strings.Join(terms, " & "), nil
+}
+
+// tsQueryTerm joins the words of s with the FOLLOWED BY operator, keeping a
+// prefix marker on the last word when s ends with *.
+func tsQueryTerm(s string) string {
+	s = strings.TrimSpace(s)
+	prefix := strings.HasSuffix(s, "*")
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+	if prefix {
+		words[len(words)-1] += ":*"
+	}
+	return strings.Join(words, " <-> ")
+}
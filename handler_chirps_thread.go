@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+type chirpThreadNode struct {
+	Chirp
+	Replies []chirpThreadNode `json:"replies"`
+}
+
+func (cfg *apiConfig) handlerChirpsThread(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Ancestors []Chirp         `json:"ancestors"`
+		Chirp     chirpThreadNode `json:"chirp"`
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	// ancestors come root first and end with the requested chirp itself
+	dbAncestors, err := cfg.db.GetChirpAncestors(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve thread", err)
+		return
+	}
+	if len(dbAncestors) == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", nil)
+		return
+	}
+
+	dbDescendants, err := cfg.db.GetChirpDescendants(r.Context(), uuid.NullUUID{UUID: chirpID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve thread", err)
+		return
+	}
+
+	ancestors := make([]Chirp, 0, len(dbAncestors)-1)
+	for _, a := range dbAncestors[:len(dbAncestors)-1] {
+		ancestors = append(ancestors, threadChirp(a))
+	}
+
+	replies := map[uuid.UUID][]Chirp{}
+	for _, d := range dbDescendants {
+		// both CTEs select the same columns
+		reply := threadChirp(database.GetChirpAncestorsRow(d))
+		replies[d.InReplyTo.UUID] = append(replies[d.InReplyTo.UUID], reply)
+	}
+
+	self := threadChirp(dbAncestors[len(dbAncestors)-1])
+
+	likeTargets := []*Chirp{&self}
+	for i := range ancestors {
+		likeTargets = append(likeTargets, &ancestors[i])
+	}
+	for _, chirps := range replies {
+		for i := range chirps {
+			likeTargets = append(likeTargets, &chirps[i])
+		}
+	}
+	err = cfg.attachLikes(r.Context(), cfg.viewerID(r), likeTargets...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve likes", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Ancestors: ancestors,
+		Chirp:     buildThreadNode(self, replies),
+	})
+}
+
+func threadChirp(row database.GetChirpAncestorsRow) Chirp {
+	return Chirp{
+		ID:        row.ID,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+		UserID:    row.UserID,
+		Body:      row.Body,
+		InReplyTo: uuidPtr(row.InReplyTo),
+		Deleted:   row.DeletedAt.Valid,
+	}
+}
+
+func buildThreadNode(chirp Chirp, replies map[uuid.UUID][]Chirp) chirpThreadNode {
+	node := chirpThreadNode{
+		Chirp:   chirp,
+		Replies: []chirpThreadNode{},
+	}
+	for _, reply := range replies[chirp.ID] {
+		node.Replies = append(node.Replies, buildThreadNode(reply, replies))
+	}
+	return node
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+	"Chirpy/internal/moderation"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerChirpsUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	ent, err := cfg.entitlementsOf(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	verdict, ok := cfg.moderateChirp(w, r, params.Body, ent.MaxChirpLength)
+	if !ok {
+		return
+	}
+	// an empty body would turn a quote into a plain rechirp
+	if verdict.Text == "" {
+		respondWithError(w, http.StatusBadRequest, "Chirp can't be empty", nil)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// lock the row so concurrent edits can't record the same revision twice
+	dbChirp, err := qtx.GetChirpForUpdate(r.Context(), chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+	if dbChirp.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this chirp", nil)
+		return
+	}
+	if dbChirp.IsPlainRechirp {
+		respondWithError(w, http.StatusBadRequest, "Rechirps can't be edited", nil)
+		return
+	}
+	if time.Since(dbChirp.CreatedAt) > ent.EditWindow {
+		respondWithError(w, http.StatusForbidden, "This chirp can't be edited anymore", nil)
+		return
+	}
+
+	// a held edit leaves the chirp as it is until it's approved
+	if verdict.Action == moderation.Hold {
+		held, err := qtx.CreateHeldChirp(r.Context(), database.CreateHeldChirpParams{
+			UserID:  userID,
+			Body:    verdict.Text,
+			ChirpID: uuid.NullUUID{UUID: dbChirp.ID, Valid: true},
+			Reasons: verdict.Reasons,
+		})
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hold edit for review", err)
+			return
+		}
+		respondWithHeld(w, held)
+		return
+	}
+
+	_, err = qtx.CreateChirpRevision(r.Context(), database.CreateChirpRevisionParams{
+		CreatedAt: dbChirp.UpdatedAt,
+		ChirpID:   dbChirp.ID,
+		Body:      dbChirp.Body,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chirp revision", err)
+		return
+	}
+
+	chirp, err := qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+		Body: verdict.Text,
+		ID:   dbChirp.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp", err)
+		return
+	}
+
+	updated := databaseChirpToChirp(chirp)
+	err = cfg.hydrateChirps(r.Context(), uuid.NullUUID{UUID: userID, Valid: true}, &updated)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
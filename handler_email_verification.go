@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+	"Chirpy/internal/mailer"
+	"Chirpy/internal/throttle"
+
+	"github.com/google/uuid"
+)
+
+const emailVerificationExpiry = 24 * time.Hour
+
+// emailVerificationPolicy keeps a user from flooding whatever address they
+// set as their email
+var emailVerificationPolicy = throttle.Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Minute,
+	MaxDelay:     time.Hour,
+	Window:       24 * time.Hour,
+}
+
+var errEmailNotVerified = errors.New("Email address must be verified before chirping")
+
+func (cfg *apiConfig) handlerEmailVerificationSend(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		respondWithError(w, http.StatusConflict, "Email address is already verified", nil)
+		return
+	}
+
+	wait, err := cfg.emailVerificationLimiter.Attempt(r.Context(), userID.String())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check verification requests", err)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(w, http.StatusTooManyRequests, "Too many verification emails, try again later", nil)
+		return
+	}
+
+	err = cfg.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		// nothing was sent, so it doesn't count
+		if err := cfg.emailVerificationLimiter.Refund(r.Context(), userID.String()); err != nil {
+			log.Printf("Couldn't refund verification request of user %s: %s", userID, err)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerEmailVerify(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		User
+	}
+
+	userID, tokenID, email, err := auth.ValidateEmailVerificationJWT(r.URL.Query().Get("token"), cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid verification token", err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	verification, err := qtx.UseEmailVerificationToken(r.Context(), tokenID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Verification token has expired or was already used", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	if verification.UserID != userID || verification.Email != email {
+		respondWithError(w, http.StatusBadRequest, "Invalid verification token", nil)
+		return
+	}
+
+	user, err := qtx.MarkEmailVerified(r.Context(), database.MarkEmailVerifiedParams{
+		ID:    userID,
+		Email: email,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Email address has changed since the token was sent", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		User: databaseUserToUser(user),
+	})
+}
+
+// sendVerificationEmail mails the user a single-use link for their current
+// address.
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	tokenID := uuid.New()
+	_, err := cfg.db.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		ID:        tokenID,
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().UTC().Add(emailVerificationExpiry),
+	})
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.MakeEmailVerificationJWT(user.ID, user.Email, tokenID, cfg.jwtSecret, emailVerificationExpiry)
+	if err != nil {
+		return err
+	}
+
+	link := cfg.baseURL + "/api/email/verify?token=" + url.QueryEscape(token)
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Welcome to Chirpy!\n\nOpen this link within %d hours to verify your email address:\n\n%s\n",
+			int(emailVerificationExpiry.Hours()), link),
+	})
+}
+
+// checkEmailVerified returns errEmailNotVerified when the server requires a
+// verified address before chirping and the user doesn't have one yet.
+func (cfg *apiConfig) checkEmailVerified(ctx context.Context, userID uuid.UUID) error {
+	if !cfg.requireEmailVerification {
+		return nil
+	}
+	user, err := cfg.db.GetUserById(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.EmailVerifiedAt.Valid {
+		return errEmailNotVerified
+	}
+	return nil
+}
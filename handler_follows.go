@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+type Follow struct {
+	UserID     uuid.UUID `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type followsPage struct {
+	Users      []Follow `json:"users"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+func (cfg *apiConfig) handlerFollow(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	if followeeID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself", nil)
+		return
+	}
+
+	_, err = cfg.db.GetUserById(r.Context(), followeeID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+
+	err = cfg.db.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerUnfollow(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	err = cfg.db.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerFollowersGet(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollows(w, r, func(arg database.GetFollowersParams) ([]database.GetFollowersRow, error) {
+		return cfg.db.GetFollowers(r.Context(), arg)
+	})
+}
+
+func (cfg *apiConfig) handlerFollowingGet(w http.ResponseWriter, r *http.Request) {
+	cfg.respondWithFollows(w, r, func(arg database.GetFollowersParams) ([]database.GetFollowersRow, error) {
+		rows, err := cfg.db.GetFollowing(r.Context(), database.GetFollowingParams(arg))
+		follows := make([]database.GetFollowersRow, 0, len(rows))
+		for _, row := range rows {
+			follows = append(follows, database.GetFollowersRow(row))
+		}
+		return follows, err
+	})
+}
+
+// respondWithFollows serves one page of a follower or following list,
+// newest first. These lists only page forward.
+func (cfg *apiConfig) respondWithFollows(w http.ResponseWriter, r *http.Request, list func(database.GetFollowersParams) ([]database.GetFollowersRow, error)) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if page.backward() {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", nil)
+		return
+	}
+
+	_, err = cfg.db.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+
+	arg := database.GetFollowersParams{
+		UserID: userID,
+		Limit:  int32(page.Limit + 1),
+	}
+	if page.Cursor != nil {
+		arg.CursorCreatedAt.Time, arg.CursorCreatedAt.Valid = page.Cursor.CreatedAt, true
+		arg.CursorID.UUID, arg.CursorID.Valid = page.Cursor.ID, true
+	}
+	rows, err := list(arg)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+
+	rows, next, _ := paginate(rows, page, func(row database.GetFollowersRow) (time.Time, uuid.UUID) {
+		return row.CreatedAt, row.UserID
+	})
+
+	follows := make([]Follow, 0, len(rows))
+	for _, row := range rows {
+		follows = append(follows, Follow{
+			UserID:     row.UserID,
+			FollowedAt: row.CreatedAt,
+		})
+	}
+
+	setLinkHeader(w, r, next, "")
+	respondWithJSON(w, http.StatusOK, followsPage{
+		Users:      follows,
+		NextCursor: next,
+	})
+}
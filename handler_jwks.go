@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"Chirpy/internal/auth"
+)
+
+// loadKeyring builds the access token keyring from JWT_KEYS_DIR and
+// JWT_SIGNING_KEY_ID. Without them tokens are signed with the HMAC secret.
+// Once keys are configured the secret only verifies tokens if
+// JWT_ACCEPT_LEGACY_HMAC is true, which is meant for the switch: tokens
+// signed with it expire within the hour, after which the flag should go,
+// so that the secret can no longer mint access tokens.
+func loadKeyring(jwtSecret string) (*auth.Keyring, error) {
+	legacy := auth.NewHMACKey("", []byte(jwtSecret))
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		return auth.NewKeyring(legacy)
+	}
+	signingKeyID := os.Getenv("JWT_SIGNING_KEY_ID")
+	if signingKeyID == "" {
+		return nil, errors.New("JWT_SIGNING_KEY_ID must be set when JWT_KEYS_DIR is")
+	}
+	if os.Getenv("JWT_ACCEPT_LEGACY_HMAC") == "true" {
+		return auth.LoadKeyring(dir, signingKeyID, legacy)
+	}
+	return auth.LoadKeyring(dir, signingKeyID)
+}
+
+// handlerJWKS publishes the public keys other services need to verify
+// Chirpy access tokens.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.keyring.JWKS())
+}
@@ -1,23 +1,30 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"Chirpy/internal/auth"
 	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
 )
 
+const mfaChallengeExpiry = 5 * time.Minute
+
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	type response struct {
-		User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+	type mfaChallenge struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -28,49 +35,109 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+	account := loginAccountKey(params.Email)
+	if !cfg.checkLoginThrottle(w, r, account) {
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	user, err := cfg.checkLoginPassword(r.Context(), params.Email, params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
+	cfg.refundLoginAttempt(r, account)
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
-	)
+	if cfg.passwordHasher.NeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(r, user, params.Password)
+	}
+
+	totp, err := cfg.db.GetTOTPSecret(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor authentication", err)
+		return
+	}
+	if err == nil && totp.ConfirmedAt.Valid {
+		mfaToken, err := auth.MakeMFAChallengeJWT(user.ID, cfg.jwtSecret, mfaChallengeExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA challenge", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, mfaChallenge{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		})
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user)
+}
+
+// checkLoginPassword returns the user with email if password is theirs.
+// An unknown email is checked against a dummy hash, so it takes as long as
+// a wrong password and the timing doesn't tell which accounts exist.
+func (cfg *apiConfig) checkLoginPassword(ctx context.Context, email, password string) (database.User, error) {
+	user, err := cfg.db.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		auth.CheckPasswordHash(password, cfg.dummyPasswordHash)
+		return database.User{}, err
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	err = auth.CheckPasswordHash(password, user.HashedPassword)
+	if err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
+
+// respondWithLogin hands out access and refresh tokens to a user who has
+// passed every authentication step.
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	type response struct {
+		User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	cfg.resetLoginThrottle(r, user)
+
+	accessToken, err := cfg.keyring.MakeJWT(user.ID, time.Hour)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
 		return
 	}
 
-	refreshToken := auth.MakeRefreshToken()
-
-	_, err = cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
-	})
+	// every login starts a new token family
+	refreshToken, err := issueRefreshToken(r, cfg.db, user.ID, uuid.New(), uuid.NullUUID{}, nil)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		User: User{
-			ID:        user.ID,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-			Email:     user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User:         databaseUserToUser(user),
 		Token:        accessToken,
 		RefreshToken: refreshToken,
 	})
 }
+
+// rehashPassword upgrades the stored hash of a user who just proved their
+// password to the current algorithm and parameters. Login doesn't depend
+// on it, so errors are only logged.
+func (cfg *apiConfig) rehashPassword(r *http.Request, user database.User, password string) {
+	hashedPassword, err := cfg.passwordHasher.Hash(password)
+	if err != nil {
+		log.Printf("Couldn't rehash password of user %s: %s", user.ID, err)
+		return
+	}
+	// only if the password wasn't changed in the meantime
+	err = cfg.db.RehashUserPassword(r.Context(), database.RehashUserPasswordParams{
+		NewHash: hashedPassword,
+		ID:      user.ID,
+		OldHash: user.HashedPassword,
+	})
+	if err != nil {
+		log.Printf("Couldn't rehash password of user %s: %s", user.ID, err)
+	}
+}
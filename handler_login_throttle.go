@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"Chirpy/internal/database"
+	"Chirpy/internal/throttle"
+)
+
+var (
+	// loginAccountPolicy protects one account from guessing spread over
+	// many addresses
+	loginAccountPolicy = throttle.Policy{
+		FreeAttempts: 5,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}
+	// loginIPPolicy is looser since many users can share an address
+	loginIPPolicy = throttle.Policy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}
+)
+
+// loginAccountKey throttles an email the same however it's typed.
+func loginAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLoginThrottle responds with 429 and returns false if the account or
+// the client has to wait before trying again. Otherwise the attempt is
+// counted as a failure until it's refunded or the throttle reset, so
+// concurrent guesses can't all get through.
+func (cfg *apiConfig) checkLoginThrottle(w http.ResponseWriter, r *http.Request, account string) bool {
+	wait, err := cfg.reserveLoginAttempt(r, account)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return false
+	}
+	if wait == 0 {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later", nil)
+	return false
+}
+
+// reserveLoginAttempt returns how long the account or the client has to
+// wait before trying again, or 0 after counting the attempt against both.
+// The client is checked first, so a throttled client can't keep someone
+// else's account locked.
+func (cfg *apiConfig) reserveLoginAttempt(r *http.Request, account string) (time.Duration, error) {
+	ipWait, err := cfg.loginIPLimiter.Attempt(r.Context(), clientIP(r))
+	if err != nil || ipWait > 0 {
+		return ipWait, err
+	}
+	accountWait, err := cfg.loginAccountLimiter.Attempt(r.Context(), account)
+	if err != nil {
+		return 0, err
+	}
+	if accountWait > 0 {
+		if err := cfg.loginIPLimiter.Refund(r.Context(), clientIP(r)); err != nil {
+			log.Printf("Couldn't refund login attempt: %s", err)
+		}
+	}
+	return accountWait, nil
+}
+
+// refundLoginAttempt takes back a reserved attempt that succeeded. A
+// broken store shouldn't fail a login, so errors are only logged.
+func (cfg *apiConfig) refundLoginAttempt(r *http.Request, account string) {
+	if err := cfg.loginAccountLimiter.Refund(r.Context(), account); err != nil {
+		log.Printf("Couldn't refund login attempt: %s", err)
+	}
+	if err := cfg.loginIPLimiter.Refund(r.Context(), clientIP(r)); err != nil {
+		log.Printf("Couldn't refund login attempt: %s", err)
+	}
+}
+
+// resetLoginThrottle forgets the failures of a user who logged in. The
+// client's are kept, or one known password would unlock guessing others.
+func (cfg *apiConfig) resetLoginThrottle(r *http.Request, user database.User) {
+	for _, account := range []string{loginAccountKey(user.Email), user.ID.String()} {
+		if err := cfg.loginAccountLimiter.Reset(r.Context(), account); err != nil {
+			log.Printf("Couldn't reset failed logins: %s", err)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	recoveryCodeCount = 10
+)
+
+var errInvalidSecondFactor = errors.New("invalid two-factor code")
+
+// secondFactorParams is a TOTP code or, if the authenticator is lost, a
+// recovery code.
+type secondFactorParams struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// checkSecondFactor accepts a TOTP code at most once per time step and a
+// recovery code only once.
+func checkSecondFactor(ctx context.Context, q *database.Queries, totp database.TotpSecret, params secondFactorParams) error {
+	if params.RecoveryCode != "" {
+		used, err := q.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   totp.UserID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(params.RecoveryCode)),
+		})
+		if err != nil {
+			return err
+		}
+		if used == 0 {
+			return errInvalidSecondFactor
+		}
+		return nil
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, params.Code, time.Now())
+	if !ok {
+		return errInvalidSecondFactor
+	}
+	used, err := q.UseTOTPStep(ctx, database.UseTOTPStepParams{
+		UserID:       totp.UserID,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		return err
+	}
+	if used == 0 {
+		return errInvalidSecondFactor
+	}
+	return nil
+}
+
+// handlerTOTPEnroll starts TOTP enrollment. It only takes effect once a
+// code from the new secret is confirmed.
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.db.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find user", err)
+		return
+	}
+
+	totp, err := cfg.db.UpsertPendingTOTPSecret(r.Context(), database.UpsertPendingTOTPSecretParams{
+		UserID: userID,
+		Secret: auth.MakeTOTPSecret(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save TOTP secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     totp.Secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email, totp.Secret),
+	})
+}
+
+// handlerTOTPConfirm turns on TOTP with a first code from the
+// authenticator and hands out recovery codes, which are never shown again.
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't confirm TOTP", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	totp, err := qtx.GetTOTPSecret(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "TOTP enrollment wasn't started", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't confirm TOTP", err)
+		return
+	}
+	if totp.ConfirmedAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid code", nil)
+		return
+	}
+
+	err = qtx.ConfirmTOTPSecret(r.Context(), database.ConfirmTOTPSecretParams{
+		UserID:       userID,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't confirm TOTP", err)
+		return
+	}
+
+	recoveryCodes, err := replaceRecoveryCodes(r.Context(), qtx, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't confirm TOTP", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// handlerTOTPDisable turns TOTP off. It takes a second factor so a stolen
+// access token can't be used to strip it.
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := secondFactorParams{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable TOTP", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	totp, err := qtx.GetTOTPSecret(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !totp.ConfirmedAt.Valid) {
+		respondWithError(w, http.StatusNotFound, "Two-factor authentication isn't enabled", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable TOTP", err)
+		return
+	}
+
+	err = checkSecondFactor(r.Context(), qtx, totp, params)
+	if errors.Is(err, errInvalidSecondFactor) {
+		respondWithError(w, http.StatusForbidden, "Invalid code", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable TOTP", err)
+		return
+	}
+
+	err = qtx.DeleteTOTPSecret(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable TOTP", err)
+		return
+	}
+	err = qtx.DeleteRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable TOTP", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable TOTP", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLoginMFA is the second step of handlerLogin for users with TOTP
+// enabled, exchanging the MFA challenge token and a code for a login.
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken string `json:"mfa_token"`
+		secondFactorParams
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, err := auth.ValidateMFAChallengeJWT(params.MFAToken, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate MFA token", err)
+		return
+	}
+	// codes are throttled per user, the email isn't known here
+	if !cfg.checkLoginThrottle(w, r, userID.String()) {
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	totp, err := qtx.GetTOTPSecret(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !totp.ConfirmedAt.Valid) {
+		respondWithError(w, http.StatusUnauthorized, "Two-factor authentication isn't enabled", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in", err)
+		return
+	}
+
+	err = checkSecondFactor(r.Context(), qtx, totp, params.secondFactorParams)
+	if errors.Is(err, errInvalidSecondFactor) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in", err)
+		return
+	}
+	cfg.refundLoginAttempt(r, userID.String())
+
+	user, err := qtx.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find user", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in", err)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user)
+}
+
+// replaceRecoveryCodes invalidates the user's recovery codes and returns
+// a fresh set. Only their hashes are stored.
+func replaceRecoveryCodes(ctx context.Context, q *database.Queries, userID uuid.UUID) ([]string, error) {
+	err := q.DeleteRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := auth.MakeRecoveryCodes(recoveryCodeCount)
+	for _, code := range codes {
+		err := q.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(code),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
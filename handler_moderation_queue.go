@@ -0,0 +1,295 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+const (
+	heldChirpPending  = "pending"
+	heldChirpApproved = "approved"
+	heldChirpRejected = "rejected"
+)
+
+// HeldChirp is a chirp or an edit that moderation held for review.
+type HeldChirp struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Body       string     `json:"body"`
+	InReplyTo  *uuid.UUID `json:"in_reply_to,omitempty"`
+	RechirpOf  *uuid.UUID `json:"rechirp_of,omitempty"`
+	ChirpID    *uuid.UUID `json:"chirp_id,omitempty"`
+	Reasons    []string   `json:"reasons,omitempty"`
+	Status     string     `json:"status"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	ReviewedBy *uuid.UUID `json:"reviewed_by,omitempty"`
+}
+
+func databaseHeldChirpToHeldChirp(h database.HeldChirp) HeldChirp {
+	held := HeldChirp{
+		ID:         h.ID,
+		CreatedAt:  h.CreatedAt,
+		UserID:     h.UserID,
+		Body:       h.Body,
+		InReplyTo:  uuidPtr(h.InReplyTo),
+		RechirpOf:  uuidPtr(h.RechirpOf),
+		ChirpID:    uuidPtr(h.ChirpID),
+		Reasons:    h.Reasons,
+		Status:     h.Status,
+		ReviewedBy: uuidPtr(h.ReviewedBy),
+	}
+	if h.ReviewedAt.Valid {
+		held.ReviewedAt = &h.ReviewedAt.Time
+	}
+	return held
+}
+
+// respondWithHeld tells the author their chirp is waiting for review. The
+// reasons are left out, so rules can't be probed.
+func respondWithHeld(w http.ResponseWriter, h database.HeldChirp) {
+	held := databaseHeldChirpToHeldChirp(h)
+	held.Reasons = nil
+	respondWithJSON(w, http.StatusAccepted, held)
+}
+
+// handlerModerationQueueGet lists held chirps, newest first, optionally
+// only those with a given status.
+func (cfg *apiConfig) handlerModerationQueueGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Chirps     []HeldChirp `json:"chirps"`
+		NextCursor string      `json:"next_cursor,omitempty"`
+	}
+
+	if _, ok := cfg.authenticateAdmin(w, r); !ok {
+		return
+	}
+
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if page.backward() {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", nil)
+		return
+	}
+
+	arg := database.ListHeldChirpsParams{
+		Limit: int32(page.Limit + 1),
+	}
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+	case heldChirpPending, heldChirpApproved, heldChirpRejected:
+		arg.Status = sql.NullString{String: status, Valid: true}
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid status", nil)
+		return
+	}
+	if page.Cursor != nil {
+		arg.CursorCreatedAt.Time, arg.CursorCreatedAt.Valid = page.Cursor.CreatedAt, true
+		arg.CursorID.UUID, arg.CursorID.Valid = page.Cursor.ID, true
+	}
+
+	dbHeld, err := cfg.db.ListHeldChirps(r.Context(), arg)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve held chirps", err)
+		return
+	}
+
+	dbHeld, next, _ := paginate(dbHeld, page, func(h database.HeldChirp) (time.Time, uuid.UUID) {
+		return h.CreatedAt, h.ID
+	})
+
+	chirps := make([]HeldChirp, 0, len(dbHeld))
+	for _, h := range dbHeld {
+		chirps = append(chirps, databaseHeldChirpToHeldChirp(h))
+	}
+
+	setLinkHeader(w, r, next, "")
+	respondWithJSON(w, http.StatusOK, response{
+		Chirps:     chirps,
+		NextCursor: next,
+	})
+}
+
+// handlerModerationQueueApprove publishes a held chirp, or applies a held
+// edit, as the author wrote it with masked words still masked. What was
+// checked when it was held is checked again, since the review may come
+// much later: an edit has to be within the edit window, and the chirp a
+// reply or quote is about mustn't have been deleted.
+func (cfg *apiConfig) handlerModerationQueueApprove(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := cfg.authenticateAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("heldID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid held chirp ID", err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't approve chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	held, err := qtx.ReviewHeldChirp(r.Context(), database.ReviewHeldChirpParams{
+		ID:         id,
+		Status:     heldChirpApproved,
+		ReviewedBy: uuid.NullUUID{UUID: adminID, Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		cfg.respondHeldChirpNotPending(w, r, id)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't approve chirp", err)
+		return
+	}
+
+	var chirp database.Chirp
+	switch {
+	case held.ChirpID.Valid:
+		current, err := qtx.GetChirpForUpdate(r.Context(), held.ChirpID.UUID)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusConflict, "The edited chirp was deleted", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+			return
+		}
+		ent, err := cfg.entitlementsOf(r.Context(), held.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if time.Since(current.CreatedAt) > ent.EditWindow {
+			respondWithError(w, http.StatusConflict, "The edit window of this chirp has passed", nil)
+			return
+		}
+		_, err = qtx.CreateChirpRevision(r.Context(), database.CreateChirpRevisionParams{
+			CreatedAt: current.UpdatedAt,
+			ChirpID:   current.ID,
+			Body:      current.Body,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save chirp revision", err)
+			return
+		}
+		chirp, err = qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+			Body: held.Body,
+			ID:   current.ID,
+		})
+	case held.RechirpOf.Valid:
+		if !lockHeldChirpParent(w, r, qtx, held.RechirpOf.UUID, "The quoted chirp was deleted") {
+			return
+		}
+		chirp, err = qtx.CreateQuoteChirp(r.Context(), database.CreateQuoteChirpParams{
+			Body:      held.Body,
+			UserID:    held.UserID,
+			RechirpOf: held.RechirpOf,
+		})
+	default:
+		if held.InReplyTo.Valid && !lockHeldChirpParent(w, r, qtx, held.InReplyTo.UUID, "The chirp replied to was deleted") {
+			return
+		}
+		chirp, err = qtx.CreateChirp(r.Context(), database.CreateChirpParams{
+			Body:      held.Body,
+			UserID:    held.UserID,
+			InReplyTo: held.InReplyTo,
+		})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't publish chirp", err)
+		return
+	}
+
+	held, err = qtx.SetHeldChirpChirp(r.Context(), database.SetHeldChirpChirpParams{
+		ID:      held.ID,
+		ChirpID: uuid.NullUUID{UUID: chirp.ID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't approve chirp", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't approve chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, databaseHeldChirpToHeldChirp(held))
+}
+
+// lockHeldChirpParent checks that the chirp a held reply or quote is
+// about still exists, and locks it so it can't be deleted before the
+// approval commits. It responds with 409 and returns false if it's gone.
+func lockHeldChirpParent(w http.ResponseWriter, r *http.Request, qtx *database.Queries, id uuid.UUID, goneMsg string) bool {
+	_, err := qtx.GetChirpForUpdate(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, goneMsg, err)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirp", err)
+		return false
+	}
+	return true
+}
+
+// handlerModerationQueueReject discards a held chirp or edit.
+func (cfg *apiConfig) handlerModerationQueueReject(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := cfg.authenticateAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("heldID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid held chirp ID", err)
+		return
+	}
+
+	held, err := cfg.db.ReviewHeldChirp(r.Context(), database.ReviewHeldChirpParams{
+		ID:         id,
+		Status:     heldChirpRejected,
+		ReviewedBy: uuid.NullUUID{UUID: adminID, Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		cfg.respondHeldChirpNotPending(w, r, id)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reject chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, databaseHeldChirpToHeldChirp(held))
+}
+
+// respondHeldChirpNotPending explains why a held chirp couldn't be
+// reviewed: it doesn't exist or it was reviewed already.
+func (cfg *apiConfig) respondHeldChirpNotPending(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	_, err := cfg.db.GetHeldChirp(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Couldn't find held chirp", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get held chirp", err)
+		return
+	}
+	respondWithError(w, http.StatusConflict, "This chirp was already reviewed", nil)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"Chirpy/internal/database"
+	"Chirpy/internal/moderation"
+
+	"github.com/google/uuid"
+)
+
+// ModerationRule is a moderation rule added through the admin API. Rules
+// from the word list file aren't listed.
+type ModerationRule struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	CreatedBy *uuid.UUID `json:"created_by,omitempty"`
+	Kind      string     `json:"kind"`
+	Pattern   string     `json:"pattern"`
+	Action    string     `json:"action"`
+}
+
+func databaseModerationRuleToModerationRule(r database.ModerationRule) ModerationRule {
+	return ModerationRule{
+		ID:        r.ID,
+		CreatedAt: r.CreatedAt,
+		CreatedBy: uuidPtr(r.CreatedBy),
+		Kind:      r.Kind,
+		Pattern:   r.Pattern,
+		Action:    r.Action,
+	}
+}
+
+func (cfg *apiConfig) handlerModerationRulesCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Kind    moderation.Kind   `json:"kind"`
+		Pattern string            `json:"pattern"`
+		Action  moderation.Action `json:"action"`
+	}
+
+	adminID, ok := cfg.authenticateAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	rule := moderation.Rule{Kind: params.Kind, Pattern: params.Pattern, Action: params.Action}
+	if err := rule.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid rule: "+err.Error(), err)
+		return
+	}
+
+	dbRule, err := cfg.db.CreateModerationRule(r.Context(), database.CreateModerationRuleParams{
+		CreatedBy: uuid.NullUUID{UUID: adminID, Valid: true},
+		Kind:      string(rule.Kind),
+		Pattern:   rule.Pattern,
+		Action:    string(rule.Action),
+	})
+	if isUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "A rule with this pattern already exists", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create rule", err)
+		return
+	}
+	cfg.moderator.Invalidate()
+
+	respondWithJSON(w, http.StatusCreated, databaseModerationRuleToModerationRule(dbRule))
+}
+
+func (cfg *apiConfig) handlerModerationRulesGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.authenticateAdmin(w, r); !ok {
+		return
+	}
+
+	dbRules, err := cfg.db.ListModerationRules(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve rules", err)
+		return
+	}
+
+	rules := make([]ModerationRule, 0, len(dbRules))
+	for _, rule := range dbRules {
+		rules = append(rules, databaseModerationRuleToModerationRule(rule))
+	}
+
+	respondWithJSON(w, http.StatusOK, rules)
+}
+
+func (cfg *apiConfig) handlerModerationRulesDelete(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.authenticateAdmin(w, r); !ok {
+		return
+	}
+
+	ruleID, err := uuid.Parse(r.PathValue("ruleID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid rule ID", err)
+		return
+	}
+
+	deleted, err := cfg.db.DeleteModerationRule(r.Context(), ruleID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete rule", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find rule", nil)
+		return
+	}
+	cfg.moderator.Invalidate()
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+const authorizationCodeExpiry = 10 * time.Minute
+
+var scopeDescriptions = map[auth.Scope]string{
+	auth.ScopeChirpsRead:  "Read your timeline",
+	auth.ScopeChirpsWrite: "Post, edit, delete and like chirps as you",
+	auth.ScopeUsersWrite:  "Update your profile and who you follow",
+}
+
+// The consent screen is the only HTML Chirpy serves. The user signs in on
+// it, so a client never sees their password.
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>{{if .ClientName}}Authorize {{.ClientName}} - {{end}}Chirpy</title>
+  </head>
+  <body>
+    {{if .Fatal}}
+    <h1>Can't authorize this app</h1>
+    <p>{{.Error}}</p>
+    {{else}}
+    <h1>Authorize {{.ClientName}}</h1>
+    <p><strong>{{.ClientName}}</strong> wants to:</p>
+    <ul>
+      {{range .Scopes}}<li>{{.}}</li>{{end}}
+    </ul>
+    <p>You'll be sent back to {{.RedirectHost}}.</p>
+    {{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+    <form method="post" action="/oauth/authorize">
+      {{range $name, $value := .Request}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+      {{end}}
+      <label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label>
+      <label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+      <label>Two-factor code, if you've turned it on <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code"></label>
+      <button type="submit" name="decision" value="approve">Allow</button>
+      <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+    </form>
+    {{end}}
+  </body>
+</html>
+`))
+
+type consentPage struct {
+	Fatal        bool
+	Error        string
+	ClientName   string
+	Scopes       []string
+	RedirectHost string
+	Request      map[string]string
+	Email        string
+}
+
+// authorizeRequest is a validated authorization request.
+type authorizeRequest struct {
+	Client        database.OauthClient
+	RedirectURI   string
+	Scopes        []auth.Scope
+	State         string
+	CodeChallenge string
+}
+
+// parseAuthorizeRequest validates an authorization request. If the client
+// or redirect URI is wrong it shows an error instead of redirecting, so
+// Chirpy can't be used as an open redirect. Other errors are sent back to
+// the client. Either way it returns false.
+func (cfg *apiConfig) parseAuthorizeRequest(w http.ResponseWriter, r *http.Request) (authorizeRequest, bool) {
+	clientID, err := uuid.Parse(r.FormValue("client_id"))
+	if err != nil {
+		renderConsentError(w, http.StatusBadRequest, "The app's client ID is invalid.", err)
+		return authorizeRequest{}, false
+	}
+	client, err := cfg.db.GetOAuthClient(r.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		renderConsentError(w, http.StatusBadRequest, "The app isn't registered with Chirpy.", err)
+		return authorizeRequest{}, false
+	}
+	if err != nil {
+		renderConsentError(w, http.StatusInternalServerError, "Something went wrong, please try again.", err)
+		return authorizeRequest{}, false
+	}
+
+	// the redirect URI may be left out if there's only one
+	redirectURI := r.FormValue("redirect_uri")
+	if redirectURI == "" && len(client.RedirectUris) == 1 {
+		redirectURI = client.RedirectUris[0]
+	}
+	if !slices.Contains(client.RedirectUris, redirectURI) {
+		renderConsentError(w, http.StatusBadRequest, "The app's redirect URI isn't registered.", nil)
+		return authorizeRequest{}, false
+	}
+
+	req := authorizeRequest{
+		Client:        client,
+		RedirectURI:   redirectURI,
+		State:         r.FormValue("state"),
+		CodeChallenge: r.FormValue("code_challenge"),
+	}
+
+	if r.FormValue("response_type") != "code" {
+		redirectWithAuthorizeError(w, r, req, "unsupported_response_type", "Only the code response type is supported")
+		return authorizeRequest{}, false
+	}
+	// PKCE is required of every client, with S256 only
+	if req.CodeChallenge == "" || r.FormValue("code_challenge_method") != "S256" {
+		redirectWithAuthorizeError(w, r, req, "invalid_request", "PKCE with code_challenge_method S256 is required")
+		return authorizeRequest{}, false
+	}
+	req.Scopes, err = auth.ParseScopes(strings.Fields(r.FormValue("scope")))
+	if err != nil {
+		redirectWithAuthorizeError(w, r, req, "invalid_scope", err.Error())
+		return authorizeRequest{}, false
+	}
+	if len(req.Scopes) == 0 {
+		redirectWithAuthorizeError(w, r, req, "invalid_scope", "At least one scope is required")
+		return authorizeRequest{}, false
+	}
+
+	return req, true
+}
+
+// handlerOAuthAuthorize shows the consent screen.
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, ok := cfg.parseAuthorizeRequest(w, r)
+	if !ok {
+		return
+	}
+	renderConsent(w, r, http.StatusOK, req, "")
+}
+
+// handlerOAuthAuthorizeDecision signs the user in and, if they allow it,
+// sends the client back an authorization code. Signing in is throttled
+// like handlerLogin and asks for the second factor if there is one.
+func (cfg *apiConfig) handlerOAuthAuthorizeDecision(w http.ResponseWriter, r *http.Request) {
+	req, ok := cfg.parseAuthorizeRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.PostFormValue("decision") != "approve" {
+		redirectWithAuthorizeError(w, r, req, "access_denied", "The user denied the request")
+		return
+	}
+
+	email := r.PostFormValue("email")
+	account := loginAccountKey(email)
+	wait, err := cfg.reserveLoginAttempt(r, account)
+	if err != nil {
+		renderConsentError(w, http.StatusInternalServerError, "Something went wrong, please try again.", err)
+		return
+	}
+	if wait > 0 {
+		renderConsent(w, r, http.StatusTooManyRequests, req, "Too many failed login attempts, try again later.")
+		return
+	}
+
+	user, err := cfg.checkLoginPassword(r.Context(), email, r.PostFormValue("password"))
+	if err != nil {
+		renderConsent(w, r, http.StatusUnauthorized, req, "Incorrect email or password.")
+		return
+	}
+	cfg.refundLoginAttempt(r, account)
+	if cfg.passwordHasher.NeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(r, user, r.PostFormValue("password"))
+	}
+
+	totp, err := cfg.db.GetTOTPSecret(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		renderConsentError(w, http.StatusInternalServerError, "Something went wrong, please try again.", err)
+		return
+	}
+	if err == nil && totp.ConfirmedAt.Valid {
+		// second factor failures count against the user, like handlerLoginMFA
+		wait, err := cfg.reserveLoginAttempt(r, user.ID.String())
+		if err != nil {
+			renderConsentError(w, http.StatusInternalServerError, "Something went wrong, please try again.", err)
+			return
+		}
+		if wait > 0 {
+			renderConsent(w, r, http.StatusTooManyRequests, req, "Too many failed login attempts, try again later.")
+			return
+		}
+		err = checkSecondFactor(r.Context(), cfg.db, totp, secondFactorParams{
+			Code: r.PostFormValue("code"),
+		})
+		if errors.Is(err, errInvalidSecondFactor) {
+			renderConsent(w, r, http.StatusUnauthorized, req, "Incorrect two-factor code.")
+			return
+		}
+		if err != nil {
+			renderConsentError(w, http.StatusInternalServerError, "Something went wrong, please try again.", err)
+			return
+		}
+		cfg.refundLoginAttempt(r, user.ID.String())
+	}
+	cfg.resetLoginThrottle(r, user)
+
+	code := auth.MakeOpaqueToken()
+	err = cfg.db.CreateOAuthAuthorizationCode(r.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ExpiresAt:     time.Now().UTC().Add(authorizationCodeExpiry),
+		ClientID:      req.Client.ID,
+		UserID:        user.ID,
+		RedirectUri:   req.RedirectURI,
+		Scopes:        scopeStrings(req.Scopes),
+		CodeChallenge: req.CodeChallenge,
+		// the tokens this code is exchanged for start a new family
+		FamilyID: uuid.New(),
+	})
+	if err != nil {
+		renderConsentError(w, http.StatusInternalServerError, "Something went wrong, please try again.", err)
+		return
+	}
+
+	redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+func scopeStrings(scopes []auth.Scope) []string {
+	s := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		s = append(s, string(scope))
+	}
+	return s
+}
+
+func redirectWithAuthorizeError(w http.ResponseWriter, r *http.Request, req authorizeRequest, code, description string) {
+	redirectToClient(w, r, req, url.Values{
+		"error":             {code},
+		"error_description": {description},
+	})
+}
+
+// redirectToClient sends the user back to the client with params and the
+// client's state.
+func redirectToClient(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		renderConsentError(w, http.StatusInternalServerError, "The app's redirect URI is invalid.", err)
+		return
+	}
+	query := u.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	u.RawQuery = query.Encode()
+
+	// 303 so a POST from the consent screen becomes a GET
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+func renderConsent(w http.ResponseWriter, r *http.Request, code int, req authorizeRequest, msg string) {
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		scopes = append(scopes, scopeDescriptions[scope])
+	}
+	redirectHost := req.RedirectURI
+	if u, err := url.Parse(req.RedirectURI); err == nil {
+		redirectHost = u.Host
+	}
+	writeConsentPage(w, code, consentPage{
+		Error:        msg,
+		ClientName:   req.Client.Name,
+		Scopes:       scopes,
+		RedirectHost: redirectHost,
+		Request: map[string]string{
+			"response_type":         "code",
+			"client_id":             req.Client.ID.String(),
+			"redirect_uri":          req.RedirectURI,
+			"scope":                 strings.Join(scopeStrings(req.Scopes), " "),
+			"state":                 req.State,
+			"code_challenge":        req.CodeChallenge,
+			"code_challenge_method": "S256",
+		},
+		Email: r.FormValue("email"),
+	})
+}
+
+func renderConsentError(w http.ResponseWriter, code int, msg string, err error) {
+	if err != nil {
+		log.Println(err)
+	}
+	writeConsentPage(w, code, consentPage{
+		Fatal: true,
+		Error: msg,
+	})
+}
+
+func writeConsentPage(w http.ResponseWriter, code int, page consentPage) {
+	// the page takes a password, so it must never be framed or cached
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := consentTemplate.Execute(w, page); err != nil {
+		log.Printf("Error rendering consent page: %s", err)
+	}
+}
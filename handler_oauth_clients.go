@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxClientNameLength   = 100
+	maxClientRedirectURIs = 10
+)
+
+// OAuthClient is a third-party app users can authorize. A confidential
+// client has a secret, which is only returned when it's registered. A
+// public one, like a mobile app, can't keep one and relies on PKCE alone.
+type OAuthClient struct {
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+}
+
+func databaseOAuthClientToOAuthClient(c database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           c.ID,
+		CreatedAt:    c.CreatedAt,
+		Name:         c.Name,
+		RedirectURIs: c.RedirectUris,
+		Confidential: c.SecretHash.Valid,
+	}
+}
+
+// validateRedirectURI allows https URIs, and plain http only back to the
+// user's own machine, for native apps. Fragments aren't allowed since the
+// code is added to the query.
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return errors.New("Redirect URIs must be absolute URLs")
+	}
+	if u.Fragment != "" || strings.Contains(raw, "#") {
+		return errors.New("Redirect URIs can't have a fragment")
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	}
+	return errors.New("Redirect URIs must use https, or http to localhost")
+}
+
+func (cfg *apiConfig) handlerOAuthClientsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > maxClientNameLength {
+		respondWithError(w, http.StatusBadRequest, "Name must be between 1 and 100 characters", nil)
+		return
+	}
+	if len(params.RedirectURIs) == 0 || len(params.RedirectURIs) > maxClientRedirectURIs {
+		respondWithError(w, http.StatusBadRequest, "A client needs between 1 and 10 redirect URIs", nil)
+		return
+	}
+	for _, redirectURI := range params.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	secret := ""
+	secretHash := sql.NullString{}
+	if params.Confidential {
+		secret = auth.MakeOpaqueToken()
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.db.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		OwnerID:      userID,
+		Name:         params.Name,
+		RedirectUris: params.RedirectURIs,
+		SecretHash:   secretHash,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create client", err)
+		return
+	}
+
+	resp := databaseOAuthClientToOAuthClient(client)
+	resp.ClientSecret = secret
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (cfg *apiConfig) handlerOAuthClientsGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	dbClients, err := cfg.db.ListOAuthClients(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve clients", err)
+		return
+	}
+
+	clients := make([]OAuthClient, 0, len(dbClients))
+	for _, c := range dbClients {
+		clients = append(clients, databaseOAuthClientToOAuthClient(c))
+	}
+
+	respondWithJSON(w, http.StatusOK, clients)
+}
+
+// handlerOAuthClientsDelete removes a client along with every token users
+// granted it.
+func (cfg *apiConfig) handlerOAuthClientsDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid client ID", err)
+		return
+	}
+
+	deleted, err := cfg.db.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete client", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find client", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+const oauthAccessTokenExpiry = time.Hour
+
+// oauthTokenResponse is the token response of RFC 6749 section 5.1.
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// respondWithOAuthError responds with an error as in RFC 6749 section 5.2.
+func respondWithOAuthError(w http.ResponseWriter, code int, oauthErr, description string, err error) {
+	if err != nil {
+		log.Println(err)
+	}
+	type errorResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, errorResponse{
+		Error:            oauthErr,
+		ErrorDescription: description,
+	})
+}
+
+// handlerOAuthToken exchanges an authorization code or a client's refresh
+// token for tokens. It takes form parameters, not JSON, as OAuth clients
+// expect.
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "Couldn't parse form", err)
+		return
+	}
+
+	client, ok := cfg.authenticateOAuthClient(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.grantAuthorizationCode(w, r, client)
+	case "refresh_token":
+		cfg.grantRefreshToken(w, r, client)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Grant type must be authorization_code or refresh_token", nil)
+	}
+}
+
+// authenticateOAuthClient identifies the client by HTTP Basic auth or the
+// client_id and client_secret form parameters. Public clients only send
+// their ID.
+func (cfg *apiConfig) authenticateOAuthClient(w http.ResponseWriter, r *http.Request) (database.OauthClient, bool) {
+	clientIDParam, secret, basic := r.BasicAuth()
+	if !basic {
+		clientIDParam = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	fail := func(err error) (database.OauthClient, bool) {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		}
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed", err)
+		return database.OauthClient{}, false
+	}
+
+	clientID, err := uuid.Parse(clientIDParam)
+	if err != nil {
+		return fail(err)
+	}
+	client, err := cfg.db.GetOAuthClient(r.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fail(err)
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't authenticate client", err)
+		return database.OauthClient{}, false
+	}
+
+	if !client.SecretHash.Valid {
+		if secret != "" {
+			return fail(errors.New("public client sent a secret"))
+		}
+		return client, true
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		return fail(errors.New("wrong client secret"))
+	}
+	return client, true
+}
+
+// grantAuthorizationCode redeems an authorization code. A code works once;
+// if it's presented again it leaked, and the tokens it was exchanged for
+// are revoked.
+func (cfg *apiConfig) grantAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	codeHash := auth.HashToken(r.PostForm.Get("code"))
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't redeem code", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	code, err := qtx.GetOAuthAuthorizationCodeForUpdate(r.Context(), codeHash)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && code.ClientID != client.ID) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code", err)
+		return
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't redeem code", err)
+		return
+	}
+
+	if code.UsedAt.Valid {
+		err = qtx.RevokeRefreshTokenFamily(r.Context(), code.FamilyID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't revoke tokens", err)
+			return
+		}
+		log.Printf("Authorization code reuse for user %s, revoked token family %s", code.UserID, code.FamilyID)
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Authorization code was already used", nil)
+		return
+	}
+	if !code.ExpiresAt.After(time.Now().UTC()) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Authorization code expired", nil)
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != code.RedirectUri {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Redirect URI doesn't match the authorization request", nil)
+		return
+	}
+	if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Code verifier doesn't match the code challenge", nil)
+		return
+	}
+
+	err = qtx.UseOAuthAuthorizationCode(r.Context(), code.CodeHash)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't redeem code", err)
+		return
+	}
+
+	clientID := uuid.NullUUID{UUID: client.ID, Valid: true}
+	refreshToken, err := issueRefreshToken(r, qtx, code.UserID, code.FamilyID, clientID, code.Scopes)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't save refresh token", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't redeem code", err)
+		return
+	}
+
+	cfg.respondWithOAuthTokens(w, code.UserID, client, code.Scopes, refreshToken)
+}
+
+func (cfg *apiConfig) grantRefreshToken(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	clientID := uuid.NullUUID{UUID: client.ID, Valid: true}
+	stored, refreshToken, err := cfg.rotateRefreshToken(r, r.PostForm.Get("refresh_token"), clientID)
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errRefreshTokenReused) || errors.Is(err, errRefreshTokenExpired) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token", err)
+		return
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't refresh token", err)
+		return
+	}
+
+	cfg.respondWithOAuthTokens(w, stored.UserID, client, stored.Scopes, refreshToken)
+}
+
+func (cfg *apiConfig) respondWithOAuthTokens(w http.ResponseWriter, userID uuid.UUID, client database.OauthClient, scopes []string, refreshToken string) {
+	grantedScopes := make([]auth.Scope, 0, len(scopes))
+	for _, scope := range scopes {
+		grantedScopes = append(grantedScopes, auth.Scope(scope))
+	}
+
+	accessToken, err := cfg.keyring.MakeClientJWT(userID, client.ID.String(), grantedScopes, oauthAccessTokenExpiry)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "Couldn't create access JWT", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenExpiry.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
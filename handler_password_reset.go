@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+	"Chirpy/internal/mailer"
+	"Chirpy/internal/throttle"
+)
+
+const passwordResetExpiry = time.Hour
+
+var (
+	// passwordResetEmailPolicy keeps reset mail from flooding an inbox
+	passwordResetEmailPolicy = throttle.Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}
+	// passwordResetIPPolicy keeps one client from mailing many addresses
+	passwordResetIPPolicy = throttle.Policy{
+		FreeAttempts: 10,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}
+)
+
+// handlerPasswordForgot emails a reset token. It answers the same way
+// whether or not the email belongs to an account.
+func (cfg *apiConfig) handlerPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	// counted for every email, so being throttled says nothing about
+	// whether there is an account
+	wait, err := cfg.reservePasswordReset(r, loginAccountKey(params.Email))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check password reset requests", err)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(w, http.StatusTooManyRequests, "Too many password reset requests, try again later", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't find user", err)
+		return
+	}
+	if err == nil {
+		// sent in the background, so the response takes as long for an
+		// account as for an unknown email
+		queued := cfg.queueMail(func(ctx context.Context) {
+			err := cfg.sendPasswordReset(ctx, user)
+			if err != nil {
+				log.Printf("Couldn't send password reset email to user %s: %s", user.ID, err)
+			}
+		})
+		if !queued {
+			log.Printf("Mail queue is full, dropped password reset email to user %s", user.ID)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// reservePasswordReset returns how long the email or the client has to
+// wait before asking for another reset, or 0 after counting the request
+// against both. Like logins, the client is checked first, so it can't keep
+// someone else's email throttled once it is.
+func (cfg *apiConfig) reservePasswordReset(r *http.Request, email string) (time.Duration, error) {
+	ipWait, err := cfg.passwordResetIPLimiter.Attempt(r.Context(), clientIP(r))
+	if err != nil || ipWait > 0 {
+		return ipWait, err
+	}
+	emailWait, err := cfg.passwordResetEmailLimiter.Attempt(r.Context(), email)
+	if err != nil {
+		return 0, err
+	}
+	if emailWait > 0 {
+		if err := cfg.passwordResetIPLimiter.Refund(r.Context(), clientIP(r)); err != nil {
+			log.Printf("Couldn't refund password reset request: %s", err)
+		}
+	}
+	return emailWait, nil
+}
+
+// sendPasswordReset saves a reset token for user and emails it to them.
+func (cfg *apiConfig) sendPasswordReset(ctx context.Context, user database.User) error {
+	token := auth.MakeOpaqueToken()
+	_, err := cfg.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(passwordResetExpiry),
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+			"Your reset token is:\n\n%s\n\nIt expires in %d minutes. If it wasn't you, ignore this email.\n",
+			token, int(passwordResetExpiry.Minutes())),
+	})
+}
+
+// handlerPasswordReset sets a new password with a token from
+// handlerPasswordForgot and logs the user out everywhere.
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	resetToken, err := qtx.UsePasswordResetToken(r.Context(), auth.HashToken(params.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, "Reset token is invalid, expired or already used", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	// a refused password rolls back, so the token can be used again
+	user, err := qtx.GetUserById(r.Context(), resetToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	if !cfg.checkPassword(w, params.Password, user.Email, user.Handle.String, user.DisplayName) {
+		return
+	}
+
+	hashedPassword, err := cfg.passwordHasher.Hash(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	_, err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		HashedPassword: hashedPassword,
+		ID:             resetToken.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	err = qtx.RevokeAllRefreshTokensForUser(r.Context(), resetToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	// any other reset mail still in flight is now stale
+	err = qtx.DeletePasswordResetTokensForUser(r.Context(), resetToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
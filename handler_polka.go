@@ -1,62 +1,287 @@
 package main
 
 import (
-	"Chirpy/internal/auth"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
 
 	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) handlerPolka(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID string `json:"user_id"`
-		} `json:"data"`
+const (
+	// polkaWebhookTolerance is how far a webhook's timestamp may be from
+	// our clock. Older requests are rejected as replays.
+	polkaWebhookTolerance = 5 * time.Minute
+	maxPolkaWebhookSize   = 1 << 20
+)
+
+// Webhook event statuses. An event is processing while it's applied, and
+// a failed one is applied again when Polka retries it or an admin replays
+// it.
+const (
+	webhookStatusProcessing = "processing"
+	webhookStatusProcessed  = "processed"
+	webhookStatusIgnored    = "ignored"
+	webhookStatusFailed     = "failed"
+)
+
+// defaultChirpyRedPlan is the plan of events that don't name one.
+const defaultChirpyRedPlan = "chirpy_red"
+
+var (
+	errWebhookInvalidUserID  = errors.New("Invalid user ID")
+	errWebhookUserNotFound   = errors.New("Couldn't find user")
+	errWebhookMissingExpiry  = errors.New("Event needs an expires_at")
+	errWebhookNoSubscription = errors.New("Couldn't find subscription")
+)
+
+// polkaEvent is the body of a Polka webhook. ExpiresAt is the end of the
+// period that's paid for, or for a failed payment the end of the grace
+// period.
+type polkaEvent struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	Data  struct {
+		UserID    string     `json:"user_id"`
+		Plan      string     `json:"plan"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	} `json:"data"`
+}
+
+// polkaEventID identifies an event for deduplication. Events without an
+// ID are identified by their body and the time they were signed at, so a
+// delivery that's repeated is deduped, but the same change sent again
+// later, like a second upgrade after a downgrade, is applied.
+func polkaEventID(event polkaEvent, body []byte, sentAt time.Time) string {
+	if event.ID != "" {
+		return event.ID
 	}
+	h := sha256.New()
+	h.Write([]byte(strconv.FormatInt(sentAt.Unix(), 10) + "."))
+	h.Write(body)
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err := decoder.Decode(&params)
+// handlerPolka records every signed webhook in the event log and applies
+// it once. Duplicates of an event that was already handled are
+// acknowledged without applying it again.
+func (cfg *apiConfig) handlerPolka(w http.ResponseWriter, r *http.Request) {
+	// the signature covers the raw body, so read it before decoding
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPolkaWebhookSize))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't read body", err)
 		return
 	}
 
-	polkaKey, err := auth.GetAPIKey(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find API key", err)
+	sentAt, err := auth.VerifyWebhook(r.Header, body, time.Now(), polkaWebhookTolerance, cfg.polkaSecrets...)
+	if errors.Is(err, auth.ErrNoWebhookSignature) {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find webhook signature", err)
 		return
 	}
-	
-	if polkaKey != cfg.polkaKey {
-		respondWithError(w, http.StatusUnauthorized, "Invalid API key", err)
+	if errors.Is(err, auth.ErrWebhookTooOld) {
+		respondWithError(w, http.StatusUnauthorized, "Webhook timestamp is too old", err)
 		return
 	}
-
-	if params.Event != "user.upgraded" {
-		respondWithError(w, http.StatusNoContent, "Event != 'user.upgraded'", nil)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid webhook signature", err)
 		return
 	}
 
-	id, err := uuid.Parse(params.Data.UserID)
+	event := polkaEvent{}
+	err = json.Unmarshal(body, &event)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
 
-	user, err := cfg.db.GetUserById(r.Context(), id)
+	stored, err := cfg.db.ClaimWebhookEvent(r.Context(), database.ClaimWebhookEventParams{
+		EventID:   polkaEventID(event, body, sentAt),
+		EventType: event.Event,
+		Payload:   body,
+		SentAt:    sentAt,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// handled already, or being handled right now
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record webhook", err)
 		return
 	}
 
-	user, err = cfg.db.UpgradeToChirpyRed(r.Context(), user.ID)
+	_, err = cfg.processWebhookEvent(r.Context(), stored)
+	if errors.Is(err, errWebhookInvalidUserID) {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if errors.Is(err, errWebhookMissingExpiry) {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if errors.Is(err, errWebhookUserNotFound) || errors.Is(err, errWebhookNoSubscription) {
+		respondWithError(w, http.StatusNotFound, err.Error(), err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't update user", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't process webhook", err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// processWebhookEvent applies a claimed event and records the outcome. It
+// returns the updated event and the error applying it, if any.
+func (cfg *apiConfig) processWebhookEvent(ctx context.Context, stored database.WebhookEvent) (database.WebhookEvent, error) {
+	status := webhookStatusProcessed
+	applied, applyErr := cfg.applyPolkaEvent(ctx, stored.Payload, stored.SentAt)
+	if applyErr != nil {
+		status = webhookStatusFailed
+	} else if !applied {
+		status = webhookStatusIgnored
+	}
+
+	errMsg := sql.NullString{}
+	if applyErr != nil {
+		errMsg = sql.NullString{String: applyErr.Error(), Valid: true}
+	}
+	// the context may be canceled by now, but the outcome must be kept
+	finished, err := cfg.db.FinishWebhookEvent(context.WithoutCancel(ctx), database.FinishWebhookEventParams{
+		ID:     stored.ID,
+		Status: status,
+		Error:  errMsg,
+	})
+	if err != nil {
+		log.Printf("Couldn't record outcome of webhook event %s: %s", stored.ID, err)
+		finished = stored
+	}
+	return finished, applyErr
+}
+
+// applyPolkaEvent applies an event's payload and reports whether it was
+// applied at all. Events update the user's subscription, and
+// is_chirpy_red follows from it. Events of types Chirpy doesn't handle are
+// ignored, and so are events sent before the last change to the
+// subscription, so one delivered late can't undo a newer one.
+func (cfg *apiConfig) applyPolkaEvent(ctx context.Context, payload []byte, sentAt time.Time) (bool, error) {
+	event := polkaEvent{}
+	err := json.Unmarshal(payload, &event)
+	if err != nil {
+		return false, err
+	}
+
+	switch event.Event {
+	case "user.upgraded", "user.downgraded", "subscription.renewed", "subscription.canceled", "subscription.payment_failed":
+	default:
+		return false, nil
+	}
+
+	userID, err := uuid.Parse(event.Data.UserID)
+	if err != nil {
+		return true, errWebhookInvalidUserID
+	}
+	expiresAt := sql.NullTime{}
+	if event.Data.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: event.Data.ExpiresAt.UTC(), Valid: true}
+	}
+	plan := event.Data.Plan
+	if plan == "" {
+		plan = defaultChirpyRedPlan
+	}
+
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return true, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	_, err = qtx.GetUserById(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, errWebhookUserNotFound
+	}
+	if err != nil {
+		return true, err
+	}
+
+	// locked, so events for the same user are applied one at a time
+	sub, err := qtx.GetSubscriptionForUpdate(ctx, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return true, err
+	}
+	if err == nil && sub.UpdatedAt.After(sentAt) {
+		return false, nil
+	}
+
+	switch event.Event {
+	case "user.upgraded":
+		// without an expiry the subscription runs until it's downgraded
+		_, err = qtx.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+			UserID:    userID,
+			EventAt:   sentAt,
+			Plan:      plan,
+			ExpiresAt: expiresAt,
+		})
+	case "subscription.renewed":
+		if !expiresAt.Valid {
+			return true, errWebhookMissingExpiry
+		}
+		_, err = qtx.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+			UserID:    userID,
+			EventAt:   sentAt,
+			Plan:      plan,
+			ExpiresAt: expiresAt,
+		})
+	case "subscription.canceled":
+		// Chirpy Red lasts until the end of the paid period, which has to
+		// be known, or a subscription without an expiry would never end
+		if !expiresAt.Valid && !sub.ExpiresAt.Valid {
+			return true, errWebhookMissingExpiry
+		}
+		_, err = qtx.CancelSubscription(ctx, database.CancelSubscriptionParams{
+			ExpiresAt: expiresAt,
+			EventAt:   sentAt,
+			UserID:    userID,
+		})
+	case "subscription.payment_failed":
+		// the grace period has to end, or a failed payment would keep
+		// Chirpy Red until the old expiry, or forever without one
+		if !expiresAt.Valid {
+			return true, errWebhookMissingExpiry
+		}
+		_, err = qtx.MarkSubscriptionPastDue(ctx, database.MarkSubscriptionPastDueParams{
+			ExpiresAt: expiresAt,
+			EventAt:   sentAt,
+			UserID:    userID,
+		})
+	case "user.downgraded":
+		err = qtx.EndSubscription(ctx, database.EndSubscriptionParams{
+			EventAt: sentAt,
+			UserID:  userID,
+		})
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, errWebhookNoSubscription
+	}
+	if err != nil {
+		return true, err
+	}
+
+	_, err = qtx.SyncChirpyRed(ctx, userID)
+	if err != nil {
+		return true, err
+	}
+	return true, tx.Commit()
+}
@@ -1,15 +1,105 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
 )
 
+const refreshTokenExpiry = time.Hour * 24 * 60
+
+var (
+	errRefreshTokenReused  = errors.New("refresh token was already used")
+	errRefreshTokenExpired = errors.New("refresh token is revoked or expired")
+)
+
+// issueRefreshToken creates a refresh token in the given family for the
+// client making r and returns the raw token. Only its hash is stored.
+// Tokens of an OAuth client carry its ID and the scopes the user granted.
+func issueRefreshToken(r *http.Request, q *database.Queries, userID, familyID uuid.UUID, clientID uuid.NullUUID, scopes []string) (string, error) {
+	refreshToken := auth.MakeOpaqueToken()
+
+	_, err := q.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash: auth.HashToken(refreshToken),
+		UserID:    userID,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenExpiry),
+		FamilyID:  familyID,
+		UserAgent: truncate(r.UserAgent(), maxUserAgentLength),
+		IpAddress: clientIP(r),
+		ClientID:  clientID,
+		Scopes:    scopes,
+	})
+	if err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// rotateRefreshToken swaps refreshToken for a new one in the same family
+// and returns the old one's row and the new token. A token only works for
+// the client it was issued to; logins have no client. Presenting a token
+// that was already rotated means it leaked, so the whole family is
+// revoked.
+func (cfg *apiConfig) rotateRefreshToken(r *http.Request, refreshToken string, clientID uuid.NullUUID) (database.RefreshToken, string, error) {
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		return database.RefreshToken{}, "", err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	stored, err := qtx.GetRefreshTokenForUpdate(r.Context(), auth.HashToken(refreshToken))
+	if err != nil {
+		return database.RefreshToken{}, "", err
+	}
+	if stored.ClientID != clientID {
+		return database.RefreshToken{}, "", sql.ErrNoRows
+	}
+
+	if stored.RotatedAt.Valid {
+		err = qtx.RevokeRefreshTokenFamily(r.Context(), stored.FamilyID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			return database.RefreshToken{}, "", err
+		}
+		log.Printf("Refresh token reuse for user %s, revoked token family %s", stored.UserID, stored.FamilyID)
+		return database.RefreshToken{}, "", errRefreshTokenReused
+	}
+	if stored.RevokedAt.Valid || !stored.ExpiresAt.After(time.Now().UTC()) {
+		return database.RefreshToken{}, "", errRefreshTokenExpired
+	}
+
+	err = qtx.RotateRefreshToken(r.Context(), stored.TokenHash)
+	if err != nil {
+		return database.RefreshToken{}, "", err
+	}
+
+	newRefreshToken, err := issueRefreshToken(r, qtx, stored.UserID, stored.FamilyID, stored.ClientID, stored.Scopes)
+	if err != nil {
+		return database.RefreshToken{}, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return database.RefreshToken{}, "", err
+	}
+	return stored, newRefreshToken, nil
+}
+
+// handlerRefresh swaps a login's refresh token for a new access token and
+// a new refresh token.
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,24 +108,33 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserFromRefreshToken(r.Context(), refreshToken)
-	if err != nil {
+	stored, newRefreshToken, err := cfg.rotateRefreshToken(r, refreshToken, uuid.NullUUID{})
+	if errors.Is(err, sql.ErrNoRows) {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
 		return
 	}
+	if errors.Is(err, errRefreshTokenReused) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token was already used", nil)
+		return
+	}
+	if errors.Is(err, errRefreshTokenExpired) {
+		respondWithError(w, http.StatusUnauthorized, "Refresh token is revoked or expired", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't refresh token", err)
+		return
+	}
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
-	)
+	accessToken, err := cfg.keyring.MakeJWT(stored.UserID, time.Hour)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
@@ -46,7 +145,7 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = cfg.db.RevokeRefreshToken(r.Context(), refreshToken)
+	_, err = cfg.db.RevokeRefreshToken(r.Context(), auth.HashToken(refreshToken))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
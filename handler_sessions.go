@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+const maxUserAgentLength = 512
+
+// Session is a login, i.e. a refresh token family. Its ID stays the same
+// across rotations. Access granted to an OAuth client is a session too,
+// with the client's ID.
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	SignedInAt time.Time  `json:"signed_in_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	ClientID   *uuid.UUID `json:"client_id,omitempty"`
+}
+
+func databaseSessionToSession(s database.GetActiveSessionsRow) Session {
+	return Session{
+		ID:         s.FamilyID,
+		SignedInAt: s.SignedInAt,
+		LastUsedAt: s.LastUsedAt,
+		ExpiresAt:  s.ExpiresAt,
+		UserAgent:  s.UserAgent,
+		IPAddress:  s.IpAddress,
+		ClientID:   uuidPtr(s.ClientID),
+	}
+}
+
+func (cfg *apiConfig) handlerSessionsGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	dbSessions, err := cfg.db.GetActiveSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions", err)
+		return
+	}
+
+	sessions := make([]Session, 0, len(dbSessions))
+	for _, s := range dbSessions {
+		sessions = append(sessions, databaseSessionToSession(s))
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (cfg *apiConfig) handlerSessionsDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find session", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerSessionsRevokeAll logs the user out everywhere. Access tokens
+// already handed out stay valid until they expire.
+func (cfg *apiConfig) handlerSessionsRevokeAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.RevokeAllRefreshTokensForUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientIP is the address of the peer. Forwarding headers are ignored
+// since anyone can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+type chirpsPage struct {
+	Chirps     []Chirp `json:"chirps"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	PrevCursor string  `json:"prev_cursor,omitempty"`
+}
+
+// handlerTimeline returns the chirps of everyone the caller follows,
+// newest first.
+func (cfg *apiConfig) handlerTimeline(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, auth.ScopeChirpsRead)
+	if !ok {
+		return
+	}
+
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	dbChirps, err := cfg.listTimeline(r.Context(), userID, page)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve timeline", err)
+		return
+	}
+
+	dbChirps, next, prev := paginate(dbChirps, page, func(c database.Chirp) (time.Time, uuid.UUID) {
+		return c.CreatedAt, c.ID
+	})
+
+	chirps := make([]Chirp, 0, len(dbChirps))
+	targets := make([]*Chirp, 0, len(dbChirps))
+	for i, dbChirp := range dbChirps {
+		chirps = append(chirps, databaseChirpToChirp(dbChirp))
+		targets = append(targets, &chirps[i])
+	}
+	err = cfg.hydrateChirps(r.Context(), uuid.NullUUID{UUID: userID, Valid: true}, targets...)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve timeline", err)
+		return
+	}
+
+	setLinkHeader(w, r, next, prev)
+	respondWithJSON(w, http.StatusOK, chirpsPage{
+		Chirps:     chirps,
+		NextCursor: next,
+		PrevCursor: prev,
+	})
+}
+
+func (cfg *apiConfig) listTimeline(ctx context.Context, userID uuid.UUID, page pageParams) ([]database.Chirp, error) {
+	cursorCreatedAt := sql.NullTime{}
+	cursorID := uuid.NullUUID{}
+	if page.Cursor != nil {
+		cursorCreatedAt = sql.NullTime{Time: page.Cursor.CreatedAt, Valid: true}
+		cursorID = uuid.NullUUID{UUID: page.Cursor.ID, Valid: true}
+	}
+
+	// prev cursors walk back towards newer chirps
+	if page.backward() {
+		return cfg.db.GetTimelineAsc(ctx, database.GetTimelineAscParams{
+			FollowerID:      userID,
+			CursorCreatedAt: cursorCreatedAt,
+			CursorID:        cursorID,
+			Limit:           int32(page.Limit + 1),
+		})
+	}
+	return cfg.db.GetTimelineDesc(ctx, database.GetTimelineDescParams{
+		FollowerID:      userID,
+		CursorCreatedAt: cursorCreatedAt,
+		CursorID:        cursorID,
+		Limit:           int32(page.Limit + 1),
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+const maxTokenNameLength = 100
+
+// PersonalAccessToken is a long lived token for scripts and bots. The
+// token itself is only returned when it's created.
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+func databasePersonalAccessTokenToPersonalAccessToken(t database.PersonalAccessToken) PersonalAccessToken {
+	return PersonalAccessToken{
+		ID:         t.ID,
+		CreatedAt:  t.CreatedAt,
+		Name:       t.Name,
+		Scopes:     t.Scopes,
+		ExpiresAt:  timePtr(t.ExpiresAt),
+		LastUsedAt: timePtr(t.LastUsedAt),
+	}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (cfg *apiConfig) handlerTokensCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > maxTokenNameLength {
+		respondWithError(w, http.StatusBadRequest, "Name must be between 1 and 100 characters", nil)
+		return
+	}
+	scopes, err := auth.ParseScopes(params.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if len(scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "A token needs at least one scope", nil)
+		return
+	}
+	if params.ExpiresInDays < 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in_days can't be negative", nil)
+		return
+	}
+
+	// 0 means the token never expires
+	expiresAt := sql.NullTime{}
+	if params.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().UTC().AddDate(0, 0, params.ExpiresInDays), Valid: true}
+	}
+
+	scopeNames := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scopeNames = append(scopeNames, string(scope))
+	}
+
+	token := auth.MakePersonalAccessToken()
+	dbToken, err := cfg.db.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:    userID,
+		Name:      params.Name,
+		TokenHash: auth.HashToken(token),
+		Scopes:    scopeNames,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token", err)
+		return
+	}
+
+	pat := databasePersonalAccessTokenToPersonalAccessToken(dbToken)
+	pat.Token = token
+	respondWithJSON(w, http.StatusCreated, pat)
+}
+
+func (cfg *apiConfig) handlerTokensGet(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	dbTokens, err := cfg.db.ListPersonalAccessTokens(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve tokens", err)
+		return
+	}
+
+	tokens := make([]PersonalAccessToken, 0, len(dbTokens))
+	for _, t := range dbTokens {
+		tokens = append(tokens, databasePersonalAccessTokenToPersonalAccessToken(t))
+	}
+
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+func (cfg *apiConfig) handlerTokensDelete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticate(w, r, scopeLoginOnly)
+	if !ok {
+		return
+	}
+
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token ID", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Couldn't find token", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
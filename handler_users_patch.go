@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"Chirpy/internal/auth"
+	"Chirpy/internal/database"
+)
+
+// patchField is one member of a JSON Merge Patch (RFC 7396) document. Set
+// is false when the member is absent and Null when it was sent as null.
+type patchField[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+func (f *patchField[T]) UnmarshalJSON(data []byte) error {
+	f.Set = true
+	if string(data) == "null" {
+		f.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &f.Value)
+}
+
+// ptr returns the patched value, treating null as the zero value.
+func (f patchField[T]) ptr() *T {
+	if !f.Set {
+		return nil
+	}
+	return &f.Value
+}
+
+// handlerUsersPatch applies a JSON Merge Patch to the caller's account.
+// Changing the email or password requires the current password.
+func (cfg *apiConfig) handlerUsersPatch(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email           patchField[string] `json:"email"`
+		Password        patchField[string] `json:"password"`
+		CurrentPassword string             `json:"current_password"`
+		Handle          patchField[string] `json:"handle"`
+		DisplayName     patchField[string] `json:"display_name"`
+		Bio             patchField[string] `json:"bio"`
+		AvatarURL       patchField[string] `json:"avatar_url"`
+	}
+	type response struct {
+		User
+	}
+
+	userID, ok := cfg.authenticate(w, r, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid merge patch", err)
+		return
+	}
+
+	if (params.Email.Set && (params.Email.Null || params.Email.Value == "")) ||
+		(params.Password.Set && (params.Password.Null || params.Password.Value == "")) {
+		respondWithError(w, http.StatusBadRequest, "Email and password can't be removed", nil)
+		return
+	}
+
+	profile := profileParams{
+		Handle:      params.Handle.ptr(),
+		DisplayName: params.DisplayName.ptr(),
+		Bio:         params.Bio.ptr(),
+		AvatarURL:   params.AvatarURL.ptr(),
+	}
+	if params.Handle.Null {
+		profile.Handle = nil
+	}
+	err = profile.validate()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	user, err := qtx.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	previousEmail := user.Email
+
+	if params.Email.Set || params.Password.Set {
+		err = auth.CheckPasswordHash(params.CurrentPassword, user.HashedPassword)
+		if err != nil {
+			respondWithError(w, http.StatusForbidden, "Current password is incorrect", err)
+			return
+		}
+	}
+
+	if params.Email.Set {
+		user, err = qtx.UpdateUserEmail(r.Context(), database.UpdateUserEmailParams{
+			Email: params.Email.Value,
+			ID:    userID,
+		})
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "Email is already in use", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update email", err)
+			return
+		}
+	}
+
+	if params.Password.Set {
+		if !cfg.checkPassword(w, params.Password.Value, user.Email, user.Handle.String, user.DisplayName) {
+			return
+		}
+		hashedPassword, err := cfg.passwordHasher.Hash(params.Password.Value)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+		user, err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			HashedPassword: hashedPassword,
+			ID:             userID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+			return
+		}
+
+		// sessions opened with the old password end with it
+		err = qtx.RevokeAllRefreshTokensForUser(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+			return
+		}
+	}
+
+	if !profile.empty() || params.Handle.Null {
+		arg := profile.apply(user)
+		if params.Handle.Null {
+			arg.Handle = sql.NullString{}
+		}
+		user, err = qtx.UpdateUserProfile(r.Context(), arg)
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "Handle is already taken", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update profile", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	if user.Email != previousEmail {
+		err = cfg.sendVerificationEmail(r.Context(), user)
+		if err != nil {
+			log.Printf("Couldn't send verification email to user %s: %s", user.ID, err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		User: databaseUserToUser(user),
+	})
+}
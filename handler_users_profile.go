@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxAvatarURLLength   = 2048
+)
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+// Profile is the public view of a user. It must never carry the email.
+type Profile struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Badges      []string  `json:"badges"`
+}
+
+func (cfg *apiConfig) handlerUsersProfileGet(w http.ResponseWriter, r *http.Request) {
+	handle := strings.ToLower(r.PathValue("handle"))
+
+	user, err := cfg.db.GetUserByHandle(r.Context(), sql.NullString{String: handle, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+
+	plan, err := cfg.planOf(r.Context(), user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get plan", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Profile{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		Handle:      user.Handle.String,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarUrl,
+		IsChirpyRed: user.IsChirpyRed,
+		Badges:      cfg.plans.For(plan).Badges,
+	})
+}
+
+// profileParams holds the profile fields of an update request. Fields left
+// out of the request are nil and keep their current value.
+type profileParams struct {
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	AvatarURL   *string `json:"avatar_url"`
+}
+
+func (p profileParams) empty() bool {
+	return p.Handle == nil && p.DisplayName == nil && p.Bio == nil && p.AvatarURL == nil
+}
+
+// validate checks the fields that are present and lowercases the handle.
+func (p *profileParams) validate() error {
+	if p.Handle != nil {
+		handle := strings.ToLower(*p.Handle)
+		if !handlePattern.MatchString(handle) {
+			return errors.New("Handle must be 3 to 30 letters, digits or underscores")
+		}
+		p.Handle = &handle
+	}
+	if p.DisplayName != nil && utf8.RuneCountInString(*p.DisplayName) > maxDisplayNameLength {
+		return errors.New("Display name is too long")
+	}
+	if p.Bio != nil && utf8.RuneCountInString(*p.Bio) > maxBioLength {
+		return errors.New("Bio is too long")
+	}
+	if p.AvatarURL != nil && *p.AvatarURL != "" {
+		if len(*p.AvatarURL) > maxAvatarURLLength {
+			return errors.New("Avatar URL is too long")
+		}
+		u, err := url.Parse(*p.AvatarURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("Avatar URL must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+// apply merges the fields that are present over the current profile.
+func (p profileParams) apply(user database.User) database.UpdateUserProfileParams {
+	arg := database.UpdateUserProfileParams{
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarUrl:   user.AvatarUrl,
+		ID:          user.ID,
+	}
+	if p.Handle != nil {
+		arg.Handle = sql.NullString{String: *p.Handle, Valid: true}
+	}
+	if p.DisplayName != nil {
+		arg.DisplayName = *p.DisplayName
+	}
+	if p.Bio != nil {
+		arg.Bio = *p.Bio
+	}
+	if p.AvatarURL != nil {
+		arg.AvatarUrl = *p.AvatarURL
+	}
+	return arg
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 
 	"Chirpy/internal/auth"
@@ -10,55 +11,135 @@ import (
 
 func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+		Password        string `json:"password"`
+		Email           string `json:"email"`
+		CurrentPassword string `json:"current_password"`
+		profileParams
 	}
 	type response struct {
 		User
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	userID, ok := cfg.authenticate(w, r, auth.ScopeUsersWrite)
+	if !ok {
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	// every field is optional, and only the ones sent are changed
+	updateCredentials := params.Email != "" || params.Password != ""
+	if !updateCredentials && params.profileParams.empty() {
+		respondWithError(w, http.StatusBadRequest, "Nothing to update", nil)
+		return
+	}
+	// a delegated token can't change credentials even with the password
+	if token, _ := auth.GetBearerToken(r.Header); updateCredentials && cfg.isDelegatedToken(token) {
+		respondWithError(w, http.StatusForbidden, "Only a login can change the email or password", nil)
+		return
+	}
+	err = params.profileParams.validate()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
-	user, err := cfg.db.UpdateUser(r.Context(), database.UpdateUserParams{
-		ID:             userID,
-		Email:          params.Email,
-		HashedPassword: hashedPassword,
-	})
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
 		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	user, err := qtx.GetUserById(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't find user", err)
+		return
+	}
+	previousEmail := user.Email
+
+	if updateCredentials {
+		err = auth.CheckPasswordHash(params.CurrentPassword, user.HashedPassword)
+		if err != nil {
+			respondWithError(w, http.StatusForbidden, "Current password is incorrect", err)
+			return
+		}
+	}
+
+	if params.Email != "" {
+		user, err = qtx.UpdateUserEmail(r.Context(), database.UpdateUserEmailParams{
+			Email: params.Email,
+			ID:    userID,
+		})
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "Email is already in use", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+			return
+		}
+	}
+
+	if params.Password != "" {
+		if !cfg.checkPassword(w, params.Password, user.Email, user.Handle.String, user.DisplayName) {
+			return
+		}
+		hashedPassword, err := cfg.passwordHasher.Hash(params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+
+		user, err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			HashedPassword: hashedPassword,
+			ID:             userID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+			return
+		}
+
+		// sessions opened with the old password end with it
+		err = qtx.RevokeAllRefreshTokensForUser(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+			return
+		}
+	}
+
+	if !params.profileParams.empty() {
+		user, err = qtx.UpdateUserProfile(r.Context(), params.profileParams.apply(user))
+		if isUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "Handle is already taken", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update profile", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	if user.Email != previousEmail {
+		err = cfg.sendVerificationEmail(r.Context(), user)
+		if err != nil {
+			log.Printf("Couldn't send verification email to user %s: %s", user.ID, err)
+		}
+	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		User: User{
-			ID:        user.ID,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-			Email:     user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User: databaseUserToUser(user),
 	})
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"Chirpy/internal/database"
+
+	"github.com/google/uuid"
+)
+
+// WebhookEvent is a received webhook and what became of it.
+type WebhookEvent struct {
+	ID         uuid.UUID       `json:"id"`
+	EventID    string          `json:"event_id"`
+	SentAt     time.Time       `json:"sent_at"`
+	ReceivedAt time.Time       `json:"received_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	Type       string          `json:"type"`
+	Payload    json.RawMessage `json:"payload"`
+	Status     string          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	Attempts   int32           `json:"attempts"`
+}
+
+func databaseWebhookEventToWebhookEvent(e database.WebhookEvent) WebhookEvent {
+	return WebhookEvent{
+		ID:         e.ID,
+		EventID:    e.EventID,
+		SentAt:     e.SentAt,
+		ReceivedAt: e.ReceivedAt,
+		UpdatedAt:  e.UpdatedAt,
+		Type:       e.EventType,
+		Payload:    e.Payload,
+		Status:     e.Status,
+		Error:      e.Error.String,
+		Attempts:   e.Attempts,
+	}
+}
+
+// handlerWebhookEventsGet lists received webhooks, newest first,
+// optionally only those with a given status.
+func (cfg *apiConfig) handlerWebhookEventsGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Events     []WebhookEvent `json:"events"`
+		NextCursor string         `json:"next_cursor,omitempty"`
+	}
+
+	if _, ok := cfg.authenticateAdmin(w, r); !ok {
+		return
+	}
+
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if page.backward() {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", nil)
+		return
+	}
+
+	arg := database.ListWebhookEventsParams{
+		Limit: int32(page.Limit + 1),
+	}
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+	case webhookStatusProcessing, webhookStatusProcessed, webhookStatusIgnored, webhookStatusFailed:
+		arg.Status = sql.NullString{String: status, Valid: true}
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid status", nil)
+		return
+	}
+	if page.Cursor != nil {
+		arg.CursorReceivedAt.Time, arg.CursorReceivedAt.Valid = page.Cursor.CreatedAt, true
+		arg.CursorID.UUID, arg.CursorID.Valid = page.Cursor.ID, true
+	}
+
+	dbEvents, err := cfg.db.ListWebhookEvents(r.Context(), arg)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhook events", err)
+		return
+	}
+
+	dbEvents, next, _ := paginate(dbEvents, page, func(e database.WebhookEvent) (time.Time, uuid.UUID) {
+		return e.ReceivedAt, e.ID
+	})
+
+	events := make([]WebhookEvent, 0, len(dbEvents))
+	for _, e := range dbEvents {
+		events = append(events, databaseWebhookEventToWebhookEvent(e))
+	}
+
+	setLinkHeader(w, r, next, "")
+	respondWithJSON(w, http.StatusOK, response{
+		Events:     events,
+		NextCursor: next,
+	})
+}
+
+// handlerWebhookEventsReplay applies a failed event again, e.g. once the
+// user it's about exists or a bug is fixed. It responds with the event's
+// new status either way.
+func (cfg *apiConfig) handlerWebhookEventsReplay(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.authenticateAdmin(w, r); !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("eventID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid event ID", err)
+		return
+	}
+
+	stored, err := cfg.db.ClaimFailedWebhookEvent(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = cfg.db.GetWebhookEvent(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find webhook event", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't replay webhook event", err)
+			return
+		}
+		respondWithError(w, http.StatusConflict, "Only failed webhook events can be replayed", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't replay webhook event", err)
+		return
+	}
+
+	// a failure is recorded on the event, which is what the admin wants
+	stored, _ = cfg.processWebhookEvent(r.Context(), stored)
+
+	respondWithJSON(w, http.StatusOK, databaseWebhookEventToWebhookEvent(stored))
+}
@@ -1,11 +1,27 @@
 package auth
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestCheckPasswordHash(t *testing.T) {
@@ -65,33 +81,34 @@ func TestCheckPasswordHash(t *testing.T) {
 
 func TestValidateJWT(t *testing.T) {
 	userID := uuid.New()
-	validToken, _ := MakeJWT(userID, "secret", time.Hour)
+	ring := legacyKeyring(t, "secret")
+	validToken, _ := ring.MakeJWT(userID, time.Hour)
 
 	tests := []struct {
 		name        string
 		tokenString string
-		tokenSecret string
+		ring        *Keyring
 		wantUserID  uuid.UUID
 		wantErr     bool
 	}{
 		{
 			name:        "Valid token",
 			tokenString: validToken,
-			tokenSecret: "secret",
+			ring:        ring,
 			wantUserID:  userID,
 			wantErr:     false,
 		},
 		{
 			name:        "Invalid token",
 			tokenString: "invalid.token.string",
-			tokenSecret: "secret",
+			ring:        ring,
 			wantUserID:  uuid.Nil,
 			wantErr:     true,
 		},
 		{
 			name:        "Wrong secret",
 			tokenString: validToken,
-			tokenSecret: "wrong_secret",
+			ring:        legacyKeyring(t, "wrong_secret"),
 			wantUserID:  uuid.Nil,
 			wantErr:     true,
 		},
@@ -99,7 +116,7 @@ func TestValidateJWT(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotUserID, err := ValidateJWT(tt.tokenString, tt.tokenSecret)
+			gotUserID, err := tt.ring.ValidateJWT(tt.tokenString)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ValidateJWT() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -111,6 +128,17 @@ func TestValidateJWT(t *testing.T) {
 	}
 }
 
+// legacyKeyring signs access tokens with secret and no key ID, like
+// tokens from before key rotation.
+func legacyKeyring(t *testing.T, secret string) *Keyring {
+	t.Helper()
+	ring, err := NewKeyring(NewHMACKey("", []byte(secret)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
 func TestGetBearerToken(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -155,3 +183,557 @@ func TestGetBearerToken(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateEmailVerificationJWT(t *testing.T) {
+	userID := uuid.New()
+	tokenID := uuid.New()
+	validToken, _ := MakeEmailVerificationJWT(userID, "a@example.com", tokenID, "secret", time.Hour)
+	expiredToken, _ := MakeEmailVerificationJWT(userID, "a@example.com", tokenID, "secret", -time.Hour)
+	accessToken, _ := legacyKeyring(t, "secret").MakeJWT(userID, time.Hour)
+
+	tests := []struct {
+		name        string
+		tokenString string
+		wantErr     bool
+	}{
+		{
+			name:        "Valid token",
+			tokenString: validToken,
+			wantErr:     false,
+		},
+		{
+			name:        "Expired token",
+			tokenString: expiredToken,
+			wantErr:     true,
+		},
+		{
+			name:        "Access token",
+			tokenString: accessToken,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUserID, gotTokenID, gotEmail, err := ValidateEmailVerificationJWT(tt.tokenString, "secret")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateEmailVerificationJWT() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if gotUserID != userID || gotTokenID != tokenID || gotEmail != "a@example.com" {
+				t.Errorf("ValidateEmailVerificationJWT() = %v, %v, %v", gotUserID, gotTokenID, gotEmail)
+			}
+		})
+	}
+
+	if _, err := legacyKeyring(t, "secret").ValidateJWT(validToken); err == nil {
+		t.Errorf("ValidateJWT() accepted an email verification token")
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	// SHA-256 test vector from FIPS 180-2
+	want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := HashToken("abc"); got != want {
+		t.Errorf("HashToken() = %v, want %v", got, want)
+	}
+
+	token := MakeOpaqueToken()
+	if len(token) != 64 || token == MakeOpaqueToken() {
+		t.Errorf("MakeOpaqueToken() = %v, want 64 random hex characters", token)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey, _ := NewKey("2025-01", rsaPriv)
+	newKey, _ := NewKey("2025-06", edPriv)
+	legacy := NewHMACKey("", []byte("secret"))
+
+	oldRing, _ := NewKeyring(oldKey)
+	ring, err := NewKeyring(newKey, oldKey, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the RSA public key must not be usable as an HMAC secret
+	publicOnly, _ := NewKey("2025-01", &rsaPriv.PublicKey)
+	publicRing, _ := NewKeyring(NewHMACKey("2025-01", x509.MarshalPKCS1PublicKey(&rsaPriv.PublicKey)))
+
+	userID := uuid.New()
+	newToken, _ := ring.MakeJWT(userID, time.Hour)
+	oldToken, _ := oldRing.MakeJWT(userID, time.Hour)
+	legacyToken, _ := legacyKeyring(t, "secret").MakeJWT(userID, time.Hour)
+	expiredToken, _ := ring.MakeJWT(userID, -time.Minute)
+	confusedToken, _ := publicRing.MakeJWT(userID, time.Hour)
+	unknownRing, _ := NewKeyring(NewHMACKey("nope", []byte("secret")))
+	unknownToken, _ := unknownRing.MakeJWT(userID, time.Hour)
+
+	tests := []struct {
+		name        string
+		tokenString string
+		wantErr     bool
+	}{
+		{"Current key", newToken, false},
+		{"Rotated out key", oldToken, false},
+		{"Legacy HS256 token", legacyToken, false},
+		{"Expired", expiredToken, true},
+		{"Public key as HMAC secret", confusedToken, true},
+		{"Unknown key ID", unknownToken, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUserID, err := ring.ValidateJWT(tt.tokenString)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateJWT() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && gotUserID != userID {
+				t.Errorf("ValidateJWT() gotUserID = %v, want %v", gotUserID, userID)
+			}
+		})
+	}
+
+	if _, err := NewKeyring(publicOnly); err == nil {
+		t.Error("NewKeyring() accepted a public key for signing")
+	}
+
+	jwks := ring.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS() has %d keys, want 2", len(jwks.Keys))
+	}
+	if k := jwks.Keys[0]; k.KeyID != "2025-01" || k.KeyType != "RSA" || k.Algorithm != "RS256" || k.E != "AQAB" {
+		t.Errorf("JWKS() RSA key = %+v", k)
+	}
+	if k := jwks.Keys[1]; k.KeyID != "2025-06" || k.KeyType != "OKP" || k.Curve != "Ed25519" || k.Algorithm != "EdDSA" {
+		t.Errorf("JWKS() Ed25519 key = %+v", k)
+	}
+}
+
+func TestParseKeyPEM(t *testing.T) {
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(edPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ParseKeyPEM("k1", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("ParseKeyPEM() error = %v", err)
+	}
+	if key.ID != "k1" || key.Method.Alg() != "EdDSA" {
+		t.Errorf("ParseKeyPEM() = %v %v, want k1 EdDSA", key.ID, key.Method.Alg())
+	}
+
+	if _, err := ParseKeyPEM("k2", []byte("not a key")); err == nil {
+		t.Error("ParseKeyPEM() accepted garbage")
+	}
+}
+
+func TestHOTPRFC6238(t *testing.T) {
+	// test vectors from RFC 6238 appendix B
+	sha1Key := []byte("12345678901234567890")
+	sha256Key := []byte("12345678901234567890123456789012")
+	sha512Key := []byte("1234567890123456789012345678901234567890123456789012345678901234")
+
+	tests := []struct {
+		unix   int64
+		sha1   string
+		sha256 string
+		sha512 string
+	}{
+		{59, "94287082", "46119246", "90693936"},
+		{1111111109, "07081804", "68084774", "25091201"},
+		{1111111111, "14050471", "67062674", "99943326"},
+		{1234567890, "89005924", "91819424", "93441116"},
+		{2000000000, "69279037", "90698825", "38618901"},
+		{20000000000, "65353130", "77737706", "47863826"},
+	}
+	for _, tt := range tests {
+		step := totpStep(time.Unix(tt.unix, 0))
+		if got := hotp(sha1Key, step, 8, sha1.New); got != tt.sha1 {
+			t.Errorf("SHA1 at %d = %v, want %v", tt.unix, got, tt.sha1)
+		}
+		if got := hotp(sha256Key, step, 8, sha256.New); got != tt.sha256 {
+			t.Errorf("SHA256 at %d = %v, want %v", tt.unix, got, tt.sha256)
+		}
+		if got := hotp(sha512Key, step, 8, sha512.New); got != tt.sha512 {
+			t.Errorf("SHA512 at %d = %v, want %v", tt.unix, got, tt.sha512)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := MakeTOTPSecret()
+	now := time.Unix(1700000000, 0)
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		at     time.Time
+		wantOK bool
+	}{
+		{"Same step", secret, code, now, true},
+		{"One step late", secret, code, now.Add(30 * time.Second), true},
+		{"Two steps late", secret, code, now.Add(61 * time.Second), false},
+		{"Wrong code", secret, "000000", now, code == "000000"},
+		{"Short code", secret, code[:5], now, false},
+		{"Invalid secret", "not base32!", code, now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(tt.secret, tt.code, tt.at)
+			if ok != tt.wantOK {
+				t.Errorf("ValidateTOTP() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && step != totpStep(now) {
+				t.Errorf("ValidateTOTP() step = %v, want %v", step, totpStep(now))
+			}
+		})
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes := MakeRecoveryCodes(10)
+	if len(codes) != 10 {
+		t.Fatalf("MakeRecoveryCodes() made %d codes, want 10", len(codes))
+	}
+	for _, code := range codes {
+		if len(code) != 19 || NormalizeRecoveryCode(code) != code {
+			t.Errorf("MakeRecoveryCodes() made %q, which doesn't normalize to itself", code)
+		}
+		typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+		if NormalizeRecoveryCode(typed) != code {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", typed, NormalizeRecoveryCode(typed), code)
+		}
+	}
+}
+
+func TestValidateMFAChallengeJWT(t *testing.T) {
+	userID := uuid.New()
+	challenge, _ := MakeMFAChallengeJWT(userID, "secret", time.Minute)
+	ring := legacyKeyring(t, "secret")
+	access, _ := ring.MakeJWT(userID, time.Minute)
+
+	if got, err := ValidateMFAChallengeJWT(challenge, "secret"); err != nil || got != userID {
+		t.Errorf("ValidateMFAChallengeJWT() = %v, %v, want %v", got, err, userID)
+	}
+	if _, err := ValidateMFAChallengeJWT(access, "secret"); err == nil {
+		t.Error("ValidateMFAChallengeJWT() accepted an access token")
+	}
+	if _, err := ring.ValidateJWT(challenge); err == nil {
+		t.Error("ValidateJWT() accepted an MFA challenge token")
+	}
+}
+
+func TestPasswordHashers(t *testing.T) {
+	argon := Argon2idHasher{Params: DefaultArgon2idParams}
+	weakArgon := Argon2idHasher{Params: Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}}
+	legacy := BcryptHasher{Cost: bcrypt.MinCost}
+
+	argonHash, err := argon.Hash("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakHash, _ := weakArgon.Hash("hunter2")
+	bcryptHash, _ := legacy.Hash("hunter2")
+
+	tests := []struct {
+		name         string
+		hash         string
+		wantRehash   bool
+		wantVerified bool
+	}{
+		{"Current argon2id", argonHash, false, true},
+		{"Weaker argon2id parameters", weakHash, true, true},
+		{"Legacy bcrypt", bcryptHash, true, true},
+		{"Tampered argon2id", argonHash[:len(argonHash)-2] + "AA", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := argon.NeedsRehash(tt.hash); got != tt.wantRehash {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.wantRehash)
+			}
+			if err := CheckPasswordHash("hunter2", tt.hash); (err == nil) != tt.wantVerified {
+				t.Errorf("CheckPasswordHash() error = %v, want verified %v", err, tt.wantVerified)
+			}
+			if err := CheckPasswordHash("hunter3", tt.hash); err == nil {
+				t.Error("CheckPasswordHash() accepted the wrong password")
+			}
+		})
+	}
+
+	if !strings.HasPrefix(argonHash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("Hash() = %v, want a PHC string", argonHash)
+	}
+	if _, err := legacy.Hash(strings.Repeat("a", 73)); err == nil {
+		t.Error("BcryptHasher.Hash() accepted a password bcrypt would truncate")
+	}
+}
+
+func TestPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password   string
+		userInputs []string
+		want       int
+	}{
+		{"password", nil, 0},
+		{"P@ssw0rd", nil, 0},
+		{"aaaaaaaaaaaa", nil, 0},
+		{"abcdefgh", nil, 0},
+		{"qwertyuiop", nil, 0},
+		{"987654321", nil, 0},
+		{"alice2024", []string{"alice@example.com"}, 1},
+		{"correcthorsebatterystaple", nil, 4},
+		{"glitter-Frog-71-lamp", nil, 4},
+	}
+	for _, tt := range tests {
+		if got := PasswordStrength(tt.password, tt.userInputs...); got != tt.want {
+			t.Errorf("PasswordStrength(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestBreachedFile(t *testing.T) {
+	hash := func(password string) string {
+		sum := sha1.Sum([]byte(password))
+		return strings.ToUpper(hex.EncodeToString(sum[:]))
+	}
+	counts := map[string]int{"hunter2": 17, "letmein": 5000, "correct horse": 3}
+	var lines []string
+	for password, count := range counts {
+		lines = append(lines, fmt.Sprintf("%s:%d", hash(password), count))
+	}
+	// padding so the binary search has to work for its money
+	for i := range 500 {
+		lines = append(lines, fmt.Sprintf("%s:1", hash(fmt.Sprint("filler", i))))
+	}
+	slices.Sort(lines)
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	breached, err := OpenBreachedFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer breached.Close()
+
+	for password, want := range counts {
+		if got, err := BreachCount(breached, password); err != nil || got != want {
+			t.Errorf("BreachCount(%q) = %v, %v, want %v", password, got, err, want)
+		}
+	}
+	for _, password := range []string{"filler0", "filler499"} {
+		if got, _ := BreachCount(breached, password); got != 1 {
+			t.Errorf("BreachCount(%q) = %v, want 1", password, got)
+		}
+	}
+	if got, _ := BreachCount(breached, "glitter-Frog-71-lamp"); got != 0 {
+		t.Errorf("BreachCount() of an unseen password = %v, want 0", got)
+	}
+}
+
+type breachedMap map[string]int
+
+func (b breachedMap) Range(prefix string) (map[string]int, error) {
+	suffixes := map[string]int{}
+	for hash, count := range b {
+		if strings.HasPrefix(hash, prefix) {
+			suffixes[strings.TrimPrefix(hash, prefix)] = count
+		}
+	}
+	return suffixes, nil
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	sum := sha1.Sum([]byte("glitter-Frog-71-lamp"))
+	policy := PasswordPolicy{
+		MinLength:   8,
+		MaxLength:   64,
+		MinStrength: 2,
+		Breached:    breachedMap{strings.ToUpper(hex.EncodeToString(sum[:])): 1},
+	}
+
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{"", []string{"too_short"}},
+		{"short", []string{"too_short"}},
+		{strings.Repeat("x", 65), []string{"too_long"}},
+		{"password123", []string{"too_weak"}},
+		{"glitter-Frog-71-lamp", []string{"breached"}},
+		{"marble-Otter-52-kite", nil},
+	}
+	for _, tt := range tests {
+		violations, err := policy.Check(tt.password, "someone@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, v := range violations {
+			got = append(got, v.Code)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Check(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		want    []Scope
+		wantErr bool
+	}{
+		{"Sorted and deduplicated", []string{"users:write", "chirps:read", "users:write"}, []Scope{ScopeChirpsRead, ScopeUsersWrite}, false},
+		{"Empty", nil, nil, false},
+		{"Unknown scope", []string{"chirps:read", "admin"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseScopes(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseScopes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	token := MakePersonalAccessToken()
+	if !IsPersonalAccessToken(token) {
+		t.Errorf("IsPersonalAccessToken(%q) = false", token)
+	}
+	jwt, _ := legacyKeyring(t, "secret").MakeJWT(uuid.New(), time.Hour)
+	if IsPersonalAccessToken(jwt) {
+		t.Error("IsPersonalAccessToken() of a JWT = true")
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got := PKCEChallenge(verifier); got != challenge {
+		t.Errorf("PKCEChallenge() = %q, want %q", got, challenge)
+	}
+
+	tests := []struct {
+		name     string
+		verifier string
+		want     bool
+	}{
+		{"Matching verifier", verifier, true},
+		{"Other verifier", strings.Repeat("a", 43), false},
+		{"Too short", "abc", false},
+		{"Challenge as verifier", challenge, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPKCE(tt.verifier, challenge); got != tt.want {
+				t.Errorf("VerifyPKCE() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientJWT(t *testing.T) {
+	ring, _ := NewKeyring(NewHMACKey("", []byte("secret")))
+	userID := uuid.New()
+
+	token, err := ring.MakeClientJWT(userID, "client", []Scope{ScopeChirpsRead, ScopeUsersWrite}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ring.ValidateAccessToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotUserID, _ := claims.UserID(); gotUserID != userID {
+		t.Errorf("UserID() = %v, want %v", gotUserID, userID)
+	}
+	if claims.ClientID != "client" {
+		t.Errorf("ClientID = %q, want %q", claims.ClientID, "client")
+	}
+	if got := claims.Scopes(); !slices.Equal(got, []Scope{ScopeChirpsRead, ScopeUsersWrite}) {
+		t.Errorf("Scopes() = %v", got)
+	}
+
+	login, _ := ring.MakeJWT(userID, time.Hour)
+	claims, err = ring.ValidateAccessToken(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ClientID != "" || claims.Scopes() != nil {
+		t.Errorf("login token has client %q and scopes %v", claims.ClientID, claims.Scopes())
+	}
+
+	if _, err := ring.MakeClientJWT(userID, "", nil, time.Hour); err == nil {
+		t.Error("MakeClientJWT() without a client ID succeeded")
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	signedAt := func(signature string) time.Time {
+		unix, _ := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+		return time.Unix(unix, 0)
+	}
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	now := time.Unix(1700000000, 0)
+	tolerance := 5 * time.Minute
+
+	tests := []struct {
+		name      string
+		signature string
+		body      []byte
+		secrets   []string
+		wantErr   error
+	}{
+		{"Valid", SignWebhook(body, now, "current"), body, []string{"current"}, nil},
+		{"Sender rotating", SignWebhook(body, now, "next", "current"), body, []string{"current"}, nil},
+		{"Receiver rotating", SignWebhook(body, now, "current"), body, []string{"next", "current"}, nil},
+		{"Wrong secret", SignWebhook(body, now, "other"), body, []string{"current"}, ErrInvalidWebhookSignature},
+		{"Tampered body", SignWebhook(body, now, "current"), []byte(`{"event":"user.upgraded"}`), []string{"current"}, ErrInvalidWebhookSignature},
+		{"Replayed", SignWebhook(body, now.Add(-10*time.Minute), "current"), body, []string{"current"}, ErrWebhookTooOld},
+		{"From the future", SignWebhook(body, now.Add(10*time.Minute), "current"), body, []string{"current"}, ErrWebhookTooOld},
+		{"Within tolerance", SignWebhook(body, now.Add(-4*time.Minute), "current"), body, []string{"current"}, nil},
+		{"Timestamp swapped", "t=1700000100," + strings.Split(SignWebhook(body, now, "current"), ",")[1], body, []string{"current"}, ErrInvalidWebhookSignature},
+		{"No signature", "", body, []string{"current"}, ErrNoWebhookSignature},
+		{"No v1 signature", "t=1700000000", body, []string{"current"}, ErrInvalidWebhookSignature},
+		{"Malformed", "garbage", body, []string{"current"}, ErrInvalidWebhookSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			if tt.signature != "" {
+				headers.Set(PolkaSignatureHeader, tt.signature)
+			}
+			sentAt, err := VerifyWebhook(headers, tt.body, now, tolerance, tt.secrets...)
+			if err != tt.wantErr {
+				t.Errorf("VerifyWebhook() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !sentAt.Equal(signedAt(tt.signature)) {
+				t.Errorf("VerifyWebhook() sent at %v, want the signed timestamp", sentAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// BreachedPasswords looks up leaked passwords k-anonymity style, like the
+// Pwned Passwords range API: the caller only hands out the first five hex
+// characters of the SHA-1 of a password and gets back every hash suffix
+// with that prefix and how often it was seen.
+type BreachedPasswords interface {
+	Range(prefix string) (map[string]int, error)
+}
+
+// BreachCount returns how often password appears in b, 0 if never.
+func BreachCount(b BreachedPasswords, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := b.Range(hash[:5])
+	if err != nil {
+		return 0, err
+	}
+	return suffixes[hash[5:]], nil
+}
+
+// BreachedFile is a local Pwned Passwords style list: lines of
+// "SHA1:COUNT", sorted by hash, as in the downloadable SHA-1 "ordered by
+// hash" file. It is binary searched on disk, so it can be far larger than
+// memory.
+type BreachedFile struct {
+	f    *os.File
+	size int64
+}
+
+// OpenBreachedFile -
+func OpenBreachedFile(path string) (*BreachedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &BreachedFile{f: f, size: info.Size()}, nil
+}
+
+// Close -
+func (b *BreachedFile) Close() error {
+	return b.f.Close()
+}
+
+// Range -
+func (b *BreachedFile) Range(prefix string) (map[string]int, error) {
+	prefix = strings.ToUpper(prefix)
+
+	// find the first line whose hash isn't below prefix
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := b.lineStart(mid)
+		if err != nil {
+			return nil, err
+		}
+		if start >= b.size {
+			hi = mid
+			continue
+		}
+		line, next, err := b.lineAt(start)
+		if err != nil {
+			return nil, err
+		}
+		if breachedHash(line) < prefix {
+			lo = next
+		} else {
+			hi = mid
+		}
+	}
+
+	suffixes := map[string]int{}
+	off, err := b.lineStart(lo)
+	if err != nil {
+		return nil, err
+	}
+	for off < b.size {
+		line, next, err := b.lineAt(off)
+		if err != nil {
+			return nil, err
+		}
+		hash := breachedHash(line)
+		if !strings.HasPrefix(hash, prefix) {
+			break
+		}
+		count := 1
+		if _, c, ok := strings.Cut(line, ":"); ok {
+			if n, err := strconv.Atoi(c); err == nil {
+				count = n
+			}
+		}
+		suffixes[hash[len(prefix):]] = count
+		off = next
+	}
+	return suffixes, nil
+}
+
+// lineStart returns the offset of the first line starting at or after off.
+func (b *BreachedFile) lineStart(off int64) (int64, error) {
+	if off == 0 {
+		return 0, nil
+	}
+	_, next, err := b.lineAt(off - 1)
+	return next, err
+}
+
+// lineAt returns the rest of the line at off and where the next one starts.
+func (b *BreachedFile) lineAt(off int64) (string, int64, error) {
+	var line []byte
+	buf := make([]byte, 128)
+	for {
+		n, err := b.f.ReadAt(buf, off+int64(len(line)))
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			line = append(line, buf[:i]...)
+			return strings.TrimSpace(string(line)), off + int64(len(line)) + 1, nil
+		}
+		line = append(line, buf[:n]...)
+		if err == io.EOF {
+			return strings.TrimSpace(string(line)), off + int64(len(line)), nil
+		}
+		if err != nil {
+			return "", 0, fmt.Errorf("reading breached password file: %w", err)
+		}
+	}
+}
+
+func breachedHash(line string) string {
+	hash, _, _ := strings.Cut(line, ":")
+	return strings.ToUpper(hash)
+}
@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Key is a JWT signing or verification key. Keys parsed from a public key
+// can only verify.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACKey makes an HS256 key. It is never published in the JWKS.
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// NewKey wraps an RSA or Ed25519 private or public key. RSA keys sign with
+// RS256 and Ed25519 keys with EdDSA.
+func NewKey(id string, key interface{}) (Key, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return Key{}, fmt.Errorf("key %q: RSA keys must be at least 2048 bits", id)
+		}
+		return Key{ID: id, Method: jwt.SigningMethodRS256, signKey: k, verifyKey: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return Key{ID: id, Method: jwt.SigningMethodRS256, verifyKey: k}, nil
+	case ed25519.PrivateKey:
+		return Key{ID: id, Method: jwt.SigningMethodEdDSA, signKey: k, verifyKey: k.Public()}, nil
+	case ed25519.PublicKey:
+		return Key{ID: id, Method: jwt.SigningMethodEdDSA, verifyKey: k}, nil
+	default:
+		return Key{}, fmt.Errorf("key %q: unsupported key type %T", id, key)
+	}
+}
+
+// ParseKeyPEM parses a PKCS#8 or PKCS#1 private key, or a PKIX public key.
+func ParseKeyPEM(id string, data []byte) (Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, fmt.Errorf("key %q: no PEM block found", id)
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return Key{}, fmt.Errorf("key %q: unsupported PEM block %q", id, block.Type)
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("key %q: %w", id, err)
+	}
+	return NewKey(id, key)
+}
+
+// Keyring signs access tokens with one key and verifies them with any of
+// its keys, so a new signing key can be rolled out while tokens signed by
+// the old one are still around.
+type Keyring struct {
+	signing Key
+	keys    map[string]Key
+}
+
+// NewKeyring makes a keyring signing with signing and also accepting
+// tokens signed by verification.
+func NewKeyring(signing Key, verification ...Key) (*Keyring, error) {
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("key %q can't sign", signing.ID)
+	}
+
+	k := &Keyring{
+		signing: signing,
+		keys:    map[string]Key{},
+	}
+	for _, key := range append([]Key{signing}, verification...) {
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+	return k, nil
+}
+
+// LoadKeyring reads every *.pem file in dir, using the file name without
+// the extension as the key ID. extra keys are only used for verification.
+func LoadKeyring(dir, signingKeyID string, extra ...Key) (*Keyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var signing *Key
+	var verification []Key
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParseKeyPEM(strings.TrimSuffix(filepath.Base(path), ".pem"), data)
+		if err != nil {
+			return nil, err
+		}
+		if key.ID == signingKeyID {
+			signing = &key
+			continue
+		}
+		verification = append(verification, key)
+	}
+	if signing == nil {
+		return nil, fmt.Errorf("signing key %q not found in %s", signingKeyID, dir)
+	}
+	return NewKeyring(*signing, append(verification, extra...)...)
+}
+
+// AccessTokenClaims are the claims of an access token. Tokens issued to
+// an OAuth client carry its ID and are limited to Scope, a space separated
+// list as in RFC 9068. Tokens without a client are full logins.
+type AccessTokenClaims struct {
+	jwt.RegisteredClaims
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// UserID -
+func (c AccessTokenClaims) UserID() (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Subject)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+	return id, nil
+}
+
+// Scopes returns the scopes of a client's token.
+func (c AccessTokenClaims) Scopes() []Scope {
+	var scopes []Scope
+	for _, s := range strings.Fields(c.Scope) {
+		scopes = append(scopes, Scope(s))
+	}
+	return scopes
+}
+
+// MakeJWT makes an access token signed with the current signing key.
+func (k *Keyring) MakeJWT(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return k.makeAccessToken(userID, "", nil, expiresIn)
+}
+
+// MakeClientJWT makes an access token for an OAuth client, limited to
+// scopes.
+func (k *Keyring) MakeClientJWT(userID uuid.UUID, clientID string, scopes []Scope, expiresIn time.Duration) (string, error) {
+	if clientID == "" {
+		return "", errors.New("client ID is required")
+	}
+	return k.makeAccessToken(userID, clientID, scopes, expiresIn)
+}
+
+func (k *Keyring) makeAccessToken(userID uuid.UUID, clientID string, scopes []Scope, expiresIn time.Duration) (string, error) {
+	scopeNames := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scopeNames = append(scopeNames, string(scope))
+	}
+	token := jwt.NewWithClaims(k.signing.Method, AccessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		ClientID: clientID,
+		Scope:    strings.Join(scopeNames, " "),
+	})
+	if k.signing.ID != "" {
+		token.Header["kid"] = k.signing.ID
+	}
+	return token.SignedString(k.signing.signKey)
+}
+
+// ValidateJWT checks an access token and returns its user. It doesn't
+// tell logins and client tokens apart, use ValidateAccessToken for that.
+func (k *Keyring) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	claims, err := k.ValidateAccessToken(tokenString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID()
+}
+
+// ValidateAccessToken checks an access token against the key named by its
+// kid header. Tokens without a kid are checked against the key with an
+// empty ID, which is how tokens from before key rotation look.
+func (k *Keyring) ValidateAccessToken(tokenString string) (AccessTokenClaims, error) {
+	claims := AccessTokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, k.keyFunc, jwt.WithValidMethods(k.methods()))
+	if err != nil {
+		return AccessTokenClaims{}, err
+	}
+	if claims.Issuer != string(TokenTypeAccess) {
+		return AccessTokenClaims{}, errors.New("invalid issuer")
+	}
+	if _, err := claims.UserID(); err != nil {
+		return AccessTokenClaims{}, err
+	}
+	return claims, nil
+}
+
+func (k *Keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	// a token must use the algorithm of its key, or an attacker could
+	// e.g. pass off a public key as an HMAC secret
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q doesn't use %s", kid, token.Method.Alg())
+	}
+	return key.verifyKey, nil
+}
+
+func (k *Keyring) methods() []string {
+	var methods []string
+	for _, key := range k.keys {
+		if !slices.Contains(methods, key.Method.Alg()) {
+			methods = append(methods, key.Method.Alg())
+		}
+	}
+	return methods
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the keyring. HMAC keys are secret and
+// left out.
+func (k *Keyring) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	slices.SortFunc(set.Keys, func(a, b JWK) int { return strings.Compare(a.KeyID, b.KeyID) })
+	return set
+}
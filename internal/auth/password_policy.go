@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// PasswordPolicy says which passwords users may choose.
+type PasswordPolicy struct {
+	MinLength int
+	// MaxLength is in bytes, which is what password hashes are bounded by.
+	MaxLength int
+	// MinStrength is the lowest PasswordStrength score accepted.
+	MinStrength int
+	// Breached is optional. Passwords found in it are refused.
+	Breached BreachedPasswords
+}
+
+// PasswordViolation is a reason a password was refused. Code is stable
+// for clients to switch on, Message is for people.
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Check returns what is wrong with password, if anything. userInputs are
+// things like the email address that make a password easy to guess.
+func (p PasswordPolicy) Check(password string, userInputs ...string) ([]PasswordViolation, error) {
+	var violations []PasswordViolation
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_short",
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_long",
+			Message: fmt.Sprintf("Password must be at most %d bytes long", p.MaxLength),
+		})
+	}
+	if len(violations) > 0 {
+		return violations, nil
+	}
+
+	if PasswordStrength(password, userInputs...) < p.MinStrength {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_weak",
+			Message: "Password is too easy to guess, try a longer password or a few uncommon words",
+		})
+	}
+
+	if p.Breached != nil {
+		count, err := BreachCount(p.Breached, password)
+		if err != nil {
+			return violations, err
+		}
+		if count > 0 {
+			violations = append(violations, PasswordViolation{
+				Code:    "breached",
+				Message: "Password has appeared in a data breach, choose another one",
+			})
+		}
+	}
+	return violations, nil
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// commonPasswords are guessed first, in this order. It is deliberately
+// short: a breached password list catches the long tail.
+var commonPasswords = []string{
+	"password", "123456", "qwerty", "letmein", "welcome", "monkey", "dragon", "football",
+	"baseball", "iloveyou", "admin", "login", "master", "sunshine", "princess", "shadow",
+	"superman", "michael", "trustno", "abc123", "secret", "hello", "freedom", "whatever",
+	"starwars", "pokemon", "batman", "summer", "winter", "spring", "autumn", "flower",
+	"love", "money", "computer", "internet", "soccer", "hockey", "charlie", "jordan",
+	"hunter", "ranger", "buster", "tigger", "cheese", "cookie", "pepper", "ginger",
+	"killer", "access", "mustang", "thomas", "jennifer", "jessica", "daniel", "orange",
+	"banana", "purple", "silver", "golden", "matrix", "passw", "pass", "chirpy", "chirp",
+	"twitter", "bird", "changeme", "default", "guest", "root", "user", "test",
+}
+
+var keyboardRows = []string{"qwertyuiop", "asdfghjkl", "zxcvbnm", "1234567890"}
+
+var unleet = strings.NewReplacer(
+	"0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i",
+)
+
+// PasswordStrength scores password from 0 (trivial to guess) to 4 (very
+// hard to guess), like zxcvbn. It estimates the guesses an attacker needs
+// by splitting the password into dictionary words, repeats, sequences and
+// keyboard runs, and brute force for the rest. userInputs, such as the
+// email address, count as dictionary words.
+func PasswordStrength(password string, userInputs ...string) int {
+	guesses := math.Log10(estimateGuesses(password, userInputs))
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+type passwordMatch struct {
+	start, end int
+	guesses    float64
+}
+
+// estimateGuesses finds the cheapest way to cover password with matches
+// and brute forced characters.
+func estimateGuesses(password string, userInputs []string) float64 {
+	original := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	if len(lower) != len(original) {
+		// some runes change length when lowercased, don't bother matching
+		lower = original
+	}
+
+	var matches []passwordMatch
+	matches = append(matches, dictionaryMatches(original, lower, userInputs)...)
+	matches = append(matches, repeatMatches(lower)...)
+	matches = append(matches, sequenceMatches(lower)...)
+	matches = append(matches, keyboardMatches(lower)...)
+
+	best := make([]float64, len(lower)+1)
+	best[0] = 1
+	for i := 1; i <= len(lower); i++ {
+		best[i] = best[i-1] * runeCardinality(original[i-1])
+		for _, m := range matches {
+			if m.end == i {
+				best[i] = min(best[i], best[m.start]*m.guesses)
+			}
+		}
+	}
+	return best[len(lower)]
+}
+
+func dictionaryMatches(original, lower []rune, userInputs []string) []passwordMatch {
+	words := map[string]float64{}
+	for i, w := range commonPasswords {
+		words[w] = float64(i + 1)
+	}
+	for _, input := range userInputs {
+		for _, w := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len(w) >= 3 {
+				words[w] = 1
+			}
+		}
+	}
+
+	// match against the password with common substitutions undone too
+	normalized := []rune(unleet.Replace(string(lower)))
+	if len(normalized) != len(lower) {
+		normalized = lower
+	}
+
+	var matches []passwordMatch
+	for i := range lower {
+		for j := i + 3; j <= len(lower); j++ {
+			segment := string(lower[i:j])
+			rank, ok := words[segment]
+			leet := false
+			if !ok {
+				rank, ok = words[string(normalized[i:j])]
+				leet = true
+			}
+			if !ok {
+				continue
+			}
+			guesses := rank
+			if segment != string(original[i:j]) {
+				guesses *= 2
+			}
+			if leet {
+				guesses *= 2
+			}
+			matches = append(matches, passwordMatch{start: i, end: j, guesses: guesses})
+		}
+	}
+	return matches
+}
+
+func repeatMatches(lower []rune) []passwordMatch {
+	var matches []passwordMatch
+	for i := 0; i < len(lower); {
+		j := i + 1
+		for j < len(lower) && lower[j] == lower[i] {
+			j++
+		}
+		if j-i >= 3 {
+			matches = append(matches, passwordMatch{start: i, end: j, guesses: runeCardinality(lower[i]) * float64(j-i)})
+		}
+		i = j
+	}
+	return matches
+}
+
+func sequenceMatches(lower []rune) []passwordMatch {
+	var matches []passwordMatch
+	for i := 0; i+2 < len(lower); {
+		delta := lower[i+1] - lower[i]
+		if delta != 1 && delta != -1 {
+			i++
+			continue
+		}
+		j := i + 2
+		for j < len(lower) && lower[j]-lower[j-1] == delta {
+			j++
+		}
+		if j-i >= 3 {
+			guesses := runeCardinality(lower[i]) * float64(j-i)
+			if strings.ContainsRune("a1z9", lower[i]) {
+				guesses = 4 * float64(j-i)
+			}
+			if delta < 0 {
+				guesses *= 2
+			}
+			matches = append(matches, passwordMatch{start: i, end: j, guesses: guesses})
+		}
+		i = j - 1
+	}
+	return matches
+}
+
+func keyboardMatches(lower []rune) []passwordMatch {
+	var matches []passwordMatch
+	for i := range lower {
+		for j := i + 4; j <= len(lower); j++ {
+			segment := string(lower[i:j])
+			for _, row := range keyboardRows {
+				if strings.Contains(row, segment) || strings.Contains(reverse(row), segment) {
+					matches = append(matches, passwordMatch{start: i, end: j, guesses: 40 * float64(j-i)})
+					break
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// runeCardinality is the size of the character class of r, the guesses
+// brute force needs for it.
+func runeCardinality(r rune) float64 {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		return 26
+	case r >= '0' && r <= '9':
+		return 10
+	case r < unicode.MaxASCII:
+		return 33
+	default:
+		return 100
+	}
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
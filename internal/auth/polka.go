@@ -1,21 +1,93 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// PolkaSignatureHeader carries the signature of a Polka webhook, like
+// "t=1700000000,v1=5257a8...". The timestamp is signed along with the
+// body, so an old request can't be replayed with a new timestamp. There
+// is a v1 signature per secret the sender has during a rotation.
+const PolkaSignatureHeader = "Polka-Signature"
 
-func GetAPIKey(headers http.Header) (string, error) {
-authHeader := headers.Get("Authorization")
-	if authHeader == "" {
-		return "", ErrNoAuthHeaderIncluded
+var (
+	ErrNoWebhookSignature      = errors.New("no webhook signature included in request")
+	ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+	ErrWebhookTooOld           = errors.New("webhook timestamp is outside the tolerance window")
+)
+
+// SignWebhook returns the PolkaSignatureHeader value for body sent at t,
+// with a signature for each secret.
+func SignWebhook(body []byte, t time.Time, secrets ...string) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	parts := []string{"t=" + timestamp}
+	for _, secret := range secrets {
+		parts = append(parts, "v1="+hex.EncodeToString(webhookMAC(secret, timestamp, body)))
+	}
+	return strings.Join(parts, ",")
+}
+
+// VerifyWebhook checks the PolkaSignatureHeader of a webhook and returns
+// the signed time it was sent at. It passes if any signature matches any
+// of secrets, so both sides can rotate secrets without downtime, and the
+// timestamp is within tolerance of now.
+func VerifyWebhook(headers http.Header, body []byte, now time.Time, tolerance time.Duration, secrets ...string) (time.Time, error) {
+	header := headers.Get(PolkaSignatureHeader)
+	if header == "" {
+		return time.Time{}, ErrNoWebhookSignature
+	}
+
+	timestamp := ""
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return time.Time{}, ErrInvalidWebhookSignature
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sig, err := hex.DecodeString(value)
+			if err != nil {
+				return time.Time{}, ErrInvalidWebhookSignature
+			}
+			signatures = append(signatures, sig)
+		}
+		// other schemes are ignored, so new ones can be added
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return time.Time{}, ErrInvalidWebhookSignature
 	}
-	splitAuth := strings.Split(authHeader, " ")
-	if len(splitAuth) < 2 || splitAuth[0] != "ApiKey" {
-		return "", errors.New("malformed authorization header")
+	sentAt := time.Unix(unix, 0).UTC()
+	if age := now.Sub(sentAt); age > tolerance || age < -tolerance {
+		return time.Time{}, ErrWebhookTooOld
+	}
+
+	for _, secret := range secrets {
+		expected := webhookMAC(secret, timestamp, body)
+		for _, sig := range signatures {
+			if hmac.Equal(sig, expected) {
+				return sentAt, nil
+			}
+		}
 	}
+	return time.Time{}, ErrInvalidWebhookSignature
+}
 
-	return splitAuth[1], nil
-}
\ No newline at end of file
+func webhookMAC(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Scope is something a token that isn't a full login may be allowed to do.
+type Scope string
+
+const (
+	ScopeChirpsRead  Scope = "chirps:read"
+	ScopeChirpsWrite Scope = "chirps:write"
+	ScopeUsersWrite  Scope = "users:write"
+)
+
+// Scopes lists every scope.
+var Scopes = []Scope{ScopeChirpsRead, ScopeChirpsWrite, ScopeUsersWrite}
+
+// ParseScopes checks that every scope exists and returns them sorted
+// without duplicates.
+func ParseScopes(names []string) ([]Scope, error) {
+	var scopes []Scope
+	for _, name := range names {
+		scope := Scope(name)
+		if !slices.Contains(Scopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", name)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	slices.Sort(scopes)
+	return scopes, nil
+}
+
+// PersonalAccessTokenPrefix tells personal access tokens apart from JWTs,
+// and makes them easy to spot for secret scanners.
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+// MakePersonalAccessToken makes a random personal access token. Store it
+// with HashToken.
+func MakePersonalAccessToken() string {
+	return PersonalAccessTokenPrefix + MakeOpaqueToken()
+}
+
+// IsPersonalAccessToken -
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
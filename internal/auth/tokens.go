@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// MakeOpaqueToken makes a random 256 bit token encoded in hex, for secrets
+// that are handed to the user once and only stored as a hash.
+func MakeOpaqueToken() string {
+	token := make([]byte, 32)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// HashToken returns the hex encoded SHA-256 of token. High entropy tokens
+// don't need a slow password hash, and a plain digest can be looked up.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// TokenTypeEmailVerification -
+	TokenTypeEmailVerification TokenType = "chirpy-email-verification"
+)
+
+// EmailVerificationClaims binds a verification token to the address it was
+// sent to, so changing the email invalidates tokens for the old one.
+type EmailVerificationClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+}
+
+// MakeEmailVerificationJWT signs a token proving ownership of email.
+// tokenID is stored server side so the token can only be used once.
+func MakeEmailVerificationJWT(
+	userID uuid.UUID,
+	email string,
+	tokenID uuid.UUID,
+	tokenSecret string,
+	expiresIn time.Duration,
+) (string, error) {
+	signingKey := []byte(tokenSecret)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, EmailVerificationClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeEmailVerification),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+			ID:        tokenID.String(),
+		},
+		Email: email,
+	})
+	return token.SignedString(signingKey)
+}
+
+// ValidateEmailVerificationJWT -
+func ValidateEmailVerificationJWT(tokenString, tokenSecret string) (userID, tokenID uuid.UUID, email string, err error) {
+	claims := EmailVerificationClaims{}
+	_, err = jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+	)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, "", err
+	}
+	if claims.Issuer != string(TokenTypeEmailVerification) {
+		return uuid.Nil, uuid.Nil, "", errors.New("invalid issuer")
+	}
+
+	userID, err = uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, "", fmt.Errorf("invalid user ID: %w", err)
+	}
+	tokenID, err = uuid.Parse(claims.ID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, "", fmt.Errorf("invalid token ID: %w", err)
+	}
+	return userID, tokenID, claims.Email, nil
+}
@@ -7,29 +7,53 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const chirpHasDependents = `-- name: ChirpHasDependents :one
+SELECT EXISTS (
+        SELECT 1
+        FROM chirps
+        WHERE in_reply_to = $1::uuid
+        OR (
+            rechirp_of = $1::uuid
+            AND NOT (is_plain_rechirp AND deleted_at IS NULL)
+        )
+    )
+`
+
+func (q *Queries) ChirpHasDependents(ctx context.Context, chirpID uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, chirpHasDependents, chirpID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to)
 VALUES (
         gen_random_uuid(),
         NOW(),
         NOW(),
         $1,
-        $2
+        $2,
+        $3
     )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of, is_plain_rechirp, search_vector
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body      string
+	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID, arg.InReplyTo)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,6 +61,86 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.IsPlainRechirp,
+		&i.SearchVector,
+	)
+	return i, err
+}
+
+const createPlainRechirp = `-- name: CreatePlainRechirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, rechirp_of, is_plain_rechirp)
+VALUES (
+        gen_random_uuid(),
+        NOW(),
+        NOW(),
+        '',
+        $1,
+        $2,
+        true
+    )
+ON CONFLICT (user_id, rechirp_of) WHERE is_plain_rechirp AND deleted_at IS NULL DO NOTHING
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of, is_plain_rechirp, search_vector
+`
+
+type CreatePlainRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.NullUUID
+}
+
+func (q *Queries) CreatePlainRechirp(ctx context.Context, arg CreatePlainRechirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, createPlainRechirp, arg.UserID, arg.RechirpOf)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.IsPlainRechirp,
+		&i.SearchVector,
+	)
+	return i, err
+}
+
+const createQuoteChirp = `-- name: CreateQuoteChirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, rechirp_of)
+VALUES (
+        gen_random_uuid(),
+        NOW(),
+        NOW(),
+        $1,
+        $2,
+        $3
+    )
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of, is_plain_rechirp, search_vector
+`
+
+type CreateQuoteChirpParams struct {
+	Body      string
+	UserID    uuid.UUID
+	RechirpOf uuid.NullUUID
+}
+
+func (q *Queries) CreateQuoteChirp(ctx context.Context, arg CreateQuoteChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, createQuoteChirp, arg.Body, arg.UserID, arg.RechirpOf)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.IsPlainRechirp,
+		&i.SearchVector,
 	)
 	return i, err
 }
@@ -51,10 +155,44 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const deletePlainRechirp = `-- name: DeletePlainRechirp :execrows
+DELETE FROM chirps
+WHERE user_id = $1
+AND rechirp_of = $2
+AND is_plain_rechirp
+AND deleted_at IS NULL
+`
+
+type DeletePlainRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.NullUUID
+}
+
+func (q *Queries) DeletePlainRechirp(ctx context.Context, arg DeletePlainRechirpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deletePlainRechirp, arg.UserID, arg.RechirpOf)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deletePlainRechirps = `-- name: DeletePlainRechirps :exec
+DELETE FROM chirps
+WHERE rechirp_of = $1
+AND is_plain_rechirp
+AND deleted_at IS NULL
+`
+
+func (q *Queries) DeletePlainRechirps(ctx context.Context, rechirpOf uuid.NullUUID) error {
+	_, err := q.db.ExecContext(ctx, deletePlainRechirps, rechirpOf)
+	return err
+}
+
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of, is_plain_rechirp, search_vector
 FROM chirps
 WHERE id = $1
+AND deleted_at IS NULL
 `
 
 func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
@@ -66,18 +204,167 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.IsPlainRechirp,
+		&i.SearchVector,
 	)
 	return i, err
 }
 
-const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id
+const getChirpAncestors = `-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, 0 AS depth
+    FROM chirps
+    WHERE chirps.id = $1
+    UNION ALL
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id,
+        chirps.in_reply_to, chirps.deleted_at, ancestors.depth + 1
+    FROM chirps
+    JOIN ancestors ON chirps.id = ancestors.in_reply_to
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, depth
+FROM ancestors
+ORDER BY depth DESC
+`
+
+type GetChirpAncestorsRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
+	DeletedAt sql.NullTime
+	Depth     int32
+}
+
+func (q *Queries) GetChirpAncestors(ctx context.Context, id uuid.UUID) ([]GetChirpAncestorsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpAncestors, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetChirpAncestorsRow
+	for rows.Next() {
+		var i GetChirpAncestorsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.Depth,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpDescendants = `-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, 1 AS depth
+    FROM chirps
+    WHERE chirps.in_reply_to = $1
+    UNION ALL
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id,
+        chirps.in_reply_to, chirps.deleted_at, descendants.depth + 1
+    FROM chirps
+    JOIN descendants ON chirps.in_reply_to = descendants.id
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, depth
+FROM descendants
+ORDER BY depth, created_at, id
+`
+
+type GetChirpDescendantsRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
+	DeletedAt sql.NullTime
+	Depth     int32
+}
+
+func (q *Queries) GetChirpDescendants(ctx context.Context, inReplyTo uuid.NullUUID) ([]GetChirpDescendantsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpDescendants, inReplyTo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetChirpDescendantsRow
+	for rows.Next() {
+		var i GetChirpDescendantsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.Depth,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpForUpdate = `-- name: GetChirpForUpdate :one
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of, is_plain_rechirp, search_vector
 FROM chirps
-ORDER BY created_at ASC
+WHERE id = $1
+AND deleted_at IS NULL
+FOR UPDATE
 `
 
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+func (q *Queries) GetChirpForUpdate(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpForUpdate, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.IsPlainRechirp,
+		&i.SearchVector,
+	)
+	return i, err
+}
+
+const getChirpsByIDs = `-- name: GetChirpsByIDs :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of, is_plain_rechirp, search_vector
+FROM chirps
+WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) GetChirpsByIDs(ctx context.Context, ids []uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByIDs, pq.Array(ids))
 	if err != nil {
 		return nil, err
 	}
@@ -91,6 +378,11 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.IsPlainRechirp,
+			&i.SearchVector,
 		); err != nil {
 			return nil, err
 		}
@@ -105,15 +397,33 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getChirpsByAuthor = `-- name: GetChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id
+const listChirpsAsc = `-- name: ListChirpsAsc :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of, is_plain_rechirp, search_vector
 FROM chirps
-where user_id = $1
-ORDER BY created_at ASC
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1::uuid)
+AND (
+        $2::timestamp IS NULL
+        OR (created_at, id) > ($2::timestamp, $3::uuid)
+    )
+ORDER BY created_at ASC, id ASC
+LIMIT $4
 `
 
-func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByAuthor, userID)
+type ListChirpsAscParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	Limit           int32
+}
+
+func (q *Queries) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAsc,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -127,6 +437,11 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.IsPlainRechirp,
+			&i.SearchVector,
 		); err != nil {
 			return nil, err
 		}
@@ -140,3 +455,193 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of, is_plain_rechirp, search_vector
+FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1::uuid)
+AND (
+        $2::timestamp IS NULL
+        OR (created_at, id) < ($2::timestamp, $3::uuid)
+    )
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type ListChirpsDescParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	Limit           int32
+}
+
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.IsPlainRechirp,
+			&i.SearchVector,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchChirps = `-- name: SearchChirps :many
+WITH matches AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of,
+        to_tsquery('english', $1) AS query,
+        ts_rank(search_vector, to_tsquery('english', $1)) AS rank
+    FROM chirps
+    WHERE search_vector @@ to_tsquery('english', $1)
+    AND deleted_at IS NULL
+    AND ($2::uuid IS NULL OR user_id = $2::uuid)
+    ORDER BY rank DESC, created_at DESC, id DESC
+    LIMIT $3
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of, rank,
+    CASE
+        WHEN $4::boolean THEN ts_headline(
+            'english',
+            -- the body is escaped first, so the highlighting is the only markup
+            replace(replace(replace(replace(replace(
+                body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;'),
+            query,
+            'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'
+        )
+        ELSE ''
+    END::text AS snippet
+FROM matches
+ORDER BY rank DESC, created_at DESC, id DESC
+`
+
+type SearchChirpsParams struct {
+	Query     string
+	AuthorID  uuid.NullUUID
+	Limit     int32
+	Highlight bool
+}
+
+type SearchChirpsRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
+	DeletedAt sql.NullTime
+	RechirpOf uuid.NullUUID
+	Rank      float32
+	Snippet   string
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.AuthorID,
+		arg.Limit,
+		arg.Highlight,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :exec
+UPDATE chirps
+SET body = '',
+    deleted_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirp, id)
+	return err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $1,
+    updated_at = NOW()
+WHERE id = $2
+RETURNING id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of, is_plain_rechirp, search_vector
+`
+
+type UpdateChirpBodyParams struct {
+	Body string
+	ID   uuid.UUID
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.Body, arg.ID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.InReplyTo,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.IsPlainRechirp,
+		&i.SearchVector,
+	)
+	return i, err
+}
@@ -10,6 +10,8 @@ import (
 )
 
 const reset = `-- name: Reset :exec
+DELETE FROM webhook_events;
+DELETE FROM login_failures;
 DELETE FROM users
 `
 
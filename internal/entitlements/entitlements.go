@@ -0,0 +1,148 @@
+// Package entitlements says what each subscription plan allows.
+package entitlements
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	// FreePlan is for users without Chirpy Red.
+	FreePlan = "free"
+	// RedPlan is the default Chirpy Red plan. Paid plans that aren't
+	// configured get its entitlements.
+	RedPlan = "chirpy_red"
+)
+
+// Entitlements are the limits and perks of a plan.
+type Entitlements struct {
+	MaxChirpLength int `json:"max_chirp_length"`
+	// EditWindow is how long after posting a chirp can be edited. Zero
+	// means chirps can't be edited.
+	EditWindow time.Duration `json:"edit_window"`
+	// MaxMediaPerChirp is for media attachments, which chirps don't have
+	// yet, so it isn't enforced. It's published so clients can plan for it.
+	MaxMediaPerChirp int `json:"max_media_per_chirp"`
+	// ChirpsPerHour limits posting. Zero means no limit.
+	ChirpsPerHour int `json:"chirps_per_hour"`
+	// Badges are shown on the user's profile.
+	Badges []string `json:"badges"`
+}
+
+// MarshalJSON writes EditWindow as a duration string like "15m0s".
+func (e Entitlements) MarshalJSON() ([]byte, error) {
+	type alias Entitlements
+	return json.Marshal(struct {
+		alias
+		EditWindow string `json:"edit_window"`
+	}{alias(e), e.EditWindow.String()})
+}
+
+// UnmarshalJSON reads EditWindow as a duration string like "15m". Fields
+// that are left out keep their value.
+func (e *Entitlements) UnmarshalJSON(data []byte) error {
+	type alias Entitlements
+	aux := struct {
+		*alias
+		EditWindow *string `json:"edit_window"`
+	}{alias: (*alias)(e)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.EditWindow != nil {
+		d, err := time.ParseDuration(*aux.EditWindow)
+		if err != nil {
+			return fmt.Errorf("invalid edit_window: %w", err)
+		}
+		e.EditWindow = d
+	}
+	return nil
+}
+
+func (e Entitlements) validate() error {
+	if e.MaxChirpLength < 1 {
+		return errors.New("max_chirp_length must be positive")
+	}
+	if e.EditWindow < 0 || e.MaxMediaPerChirp < 0 || e.ChirpsPerHour < 0 {
+		return errors.New("limits can't be negative")
+	}
+	return nil
+}
+
+// Catalog maps plans to their entitlements.
+type Catalog struct {
+	plans map[string]Entitlements
+}
+
+// Default returns the built-in plans.
+func Default() *Catalog {
+	return &Catalog{plans: map[string]Entitlements{
+		FreePlan: {
+			MaxChirpLength:   140,
+			EditWindow:       15 * time.Minute,
+			MaxMediaPerChirp: 1,
+			ChirpsPerHour:    50,
+			Badges:           []string{},
+		},
+		RedPlan: {
+			MaxChirpLength:   1000,
+			EditWindow:       time.Hour,
+			MaxMediaPerChirp: 4,
+			ChirpsPerHour:    500,
+			Badges:           []string{"chirpy_red"},
+		},
+	}}
+}
+
+// Load reads plans from JSON, an object of plan names to entitlements,
+// over the built-in plans. A plan's fields that are left out keep their
+// built-in value; new plans start from RedPlan.
+func Load(r io.Reader) (*Catalog, error) {
+	c := Default()
+	overrides := map[string]json.RawMessage{}
+	if err := json.NewDecoder(r).Decode(&overrides); err != nil {
+		return nil, err
+	}
+	for plan, raw := range overrides {
+		e, ok := c.plans[plan]
+		if !ok {
+			e = c.plans[RedPlan]
+		}
+		e.Badges = slices.Clone(e.Badges)
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return nil, fmt.Errorf("plan %s: %w", plan, err)
+		}
+		if err := e.validate(); err != nil {
+			return nil, fmt.Errorf("plan %s: %w", plan, err)
+		}
+		if e.Badges == nil {
+			e.Badges = []string{}
+		}
+		c.plans[plan] = e
+	}
+	return c, nil
+}
+
+// LoadFile is Load for a file.
+func LoadFile(path string) (*Catalog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// For returns the entitlements of plan. Unknown plans are paid ones that
+// weren't configured, so they get RedPlan's.
+func (c *Catalog) For(plan string) Entitlements {
+	if e, ok := c.plans[plan]; ok {
+		return e
+	}
+	return c.plans[RedPlan]
+}
@@ -0,0 +1,84 @@
+package entitlements
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	c, err := Load(strings.NewReader(`{
+		"free": {"max_chirp_length": 200, "edit_window": "5m"},
+		"red_yearly": {"badges": ["chirpy_red", "supporter"]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	free := c.For(FreePlan)
+	if free.MaxChirpLength != 200 || free.EditWindow != 5*time.Minute {
+		t.Errorf("free = %+v, want the overridden length and edit window", free)
+	}
+	if free.ChirpsPerHour != Default().For(FreePlan).ChirpsPerHour {
+		t.Errorf("free.ChirpsPerHour = %d, want the built-in value", free.ChirpsPerHour)
+	}
+
+	yearly := c.For("red_yearly")
+	if yearly.MaxChirpLength != c.For(RedPlan).MaxChirpLength {
+		t.Errorf("red_yearly.MaxChirpLength = %d, want RedPlan's", yearly.MaxChirpLength)
+	}
+	if !slices.Equal(yearly.Badges, []string{"chirpy_red", "supporter"}) {
+		t.Errorf("red_yearly.Badges = %v", yearly.Badges)
+	}
+	if !slices.Equal(c.For(RedPlan).Badges, []string{"chirpy_red"}) {
+		t.Errorf("RedPlan.Badges = %v, changed by another plan", c.For(RedPlan).Badges)
+	}
+
+	if got := c.For("unknown"); got.MaxChirpLength != c.For(RedPlan).MaxChirpLength {
+		t.Errorf("For(unknown) = %+v, want RedPlan's", got)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"Not JSON", `plans`},
+		{"Bad duration", `{"free": {"edit_window": "soon"}}`},
+		{"Zero length", `{"free": {"max_chirp_length": 0}}`},
+		{"Negative limit", `{"chirpy_red": {"chirps_per_hour": -1}}`},
+		{"Negative media", `{"free": {"max_media_per_chirp": -1}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(strings.NewReader(tt.json)); err == nil {
+				t.Error("Load() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestEntitlementsJSON(t *testing.T) {
+	e := Default().For(FreePlan)
+	dat, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(dat), `"edit_window":"15m0s"`) {
+		t.Errorf("json.Marshal() = %s, want edit_window as a duration string", dat)
+	}
+	if !strings.Contains(string(dat), `"max_media_per_chirp":1`) {
+		t.Errorf("json.Marshal() = %s, want max_media_per_chirp", dat)
+	}
+
+	got := Entitlements{}
+	if err := json.Unmarshal(dat, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.EditWindow != e.EditWindow || got.MaxChirpLength != e.MaxChirpLength {
+		t.Errorf("round trip = %+v, want %+v", got, e)
+	}
+}
@@ -0,0 +1,78 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogMailerWritesFile(t *testing.T) {
+	dir := t.TempDir()
+	m := &LogMailer{Dir: dir, From: "chirpy@localhost"}
+
+	err := m.Send(context.Background(), Message{
+		To:      "user@example.com",
+		Subject: "Hello\r\nBcc: evil@example.com",
+		Body:    "line one\nline two",
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one message in %s, got %v (%v)", dir, entries, err)
+	}
+	dat, err := os.ReadFile(dir + "/" + entries[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := string(dat)
+	if !strings.Contains(msg, "To: user@example.com\r\n") {
+		t.Errorf("missing To header in %q", msg)
+	}
+	if strings.Contains(msg, "\r\nBcc:") {
+		t.Errorf("header injection in %q", msg)
+	}
+	if !strings.HasSuffix(msg, "\r\n\r\nline one\r\nline two") {
+		t.Errorf("unexpected body in %q", msg)
+	}
+}
+
+func TestSMTPMailerGivesUpWithContext(t *testing.T) {
+	// a server that accepts connections but never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewSMTPMailer(host, port, "", "", "chirpy@localhost")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = m.Send(ctx, Message{To: "user@example.com", Subject: "Hello", Body: "Hi"})
+	if err == nil {
+		t.Fatal("Send() to a silent server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Send() took %v, want it to give up at the deadline", elapsed)
+	}
+}
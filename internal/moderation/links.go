@@ -0,0 +1,79 @@
+package moderation
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	domainRe = regexp.MustCompile(`^(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,}$`)
+	// linkRe finds links with or without a scheme, like
+	// https://user@www.example.com:8080/path or example.com/path.
+	linkRe = regexp.MustCompile(`(?i)\b(?:https?://(?:[^\s/@]+@)?)?((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,})\b(?::\d+)?(?:[/?#]\S*)?`)
+)
+
+func validateDomain(domain string) error {
+	if !domainRe.MatchString(strings.ToLower(domain)) {
+		return errors.New("a link rule must be a domain, like example.com")
+	}
+	return nil
+}
+
+// LinkFilter matches links to blocked domains and their subdomains.
+type LinkFilter struct {
+	domains map[string]Rule
+}
+
+// NewLinkFilter -
+func NewLinkFilter(rules []Rule) *LinkFilter {
+	domains := map[string]Rule{}
+	for _, r := range rules {
+		key := strings.ToLower(r.Pattern)
+		if prev, ok := domains[key]; !ok || r.Action.severity() > prev.Action.severity() {
+			domains[key] = r
+		}
+	}
+	return &LinkFilter{domains: domains}
+}
+
+// Check -
+func (f *LinkFilter) Check(text string) Result {
+	res := Result{Action: Allow}
+	if len(f.domains) == 0 {
+		return res
+	}
+	var masked strings.Builder
+	last := 0
+	for _, m := range linkRe.FindAllStringSubmatchIndex(text, -1) {
+		rule, ok := f.match(strings.ToLower(text[m[2]:m[3]]))
+		if !ok {
+			continue
+		}
+		res.combine(rule.Action, rule.String())
+		if rule.Action == Mask {
+			masked.WriteString(text[last:m[0]])
+			masked.WriteString(MaskText)
+			last = m[1]
+		}
+	}
+	if last > 0 {
+		masked.WriteString(text[last:])
+		res.Text = masked.String()
+	}
+	return res
+}
+
+// match finds the rule for host or the closest of its parent domains.
+func (f *LinkFilter) match(host string) (Rule, bool) {
+	for {
+		if rule, ok := f.domains[host]; ok {
+			return rule, true
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return Rule{}, false
+		}
+		host = parent
+	}
+}
@@ -0,0 +1,174 @@
+// Package moderation checks text against an ordered pipeline of filters,
+// each of which can let it through, mask parts of it, hold it for review
+// or reject it.
+package moderation
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Action is what a filter wants done with a text. Actions are ordered by
+// severity, and a pipeline's verdict is the most severe one.
+type Action string
+
+const (
+	Allow  Action = "allow"
+	Mask   Action = "mask"
+	Hold   Action = "hold"
+	Reject Action = "reject"
+)
+
+// MaskText replaces masked words.
+const MaskText = "****"
+
+// ParseAction -
+func ParseAction(s string) (Action, error) {
+	switch a := Action(s); a {
+	case Allow, Mask, Hold, Reject:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown action %q", s)
+}
+
+func (a Action) severity() int {
+	switch a {
+	case Mask:
+		return 1
+	case Hold:
+		return 2
+	case Reject:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Result is what a filter found in a text. Text is the masked text if
+// something was masked. Reasons say which rules matched.
+type Result struct {
+	Action  Action
+	Text    string
+	Reasons []string
+}
+
+// Filter is one stage of a Pipeline.
+type Filter interface {
+	Check(text string) Result
+}
+
+// Verdict is the outcome of running a text through a Pipeline.
+type Verdict struct {
+	Action  Action
+	Text    string
+	Reasons []string
+}
+
+// Pipeline runs filters in order. Each filter sees the text as masked by
+// the ones before it. It stops at the first rejection.
+type Pipeline struct {
+	filters []Filter
+}
+
+// NewPipeline -
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{filters: filters}
+}
+
+// Check -
+func (p *Pipeline) Check(text string) Verdict {
+	v := Verdict{Action: Allow, Text: text}
+	for _, f := range p.filters {
+		res := f.Check(v.Text)
+		if res.Action == Allow {
+			continue
+		}
+		v.Reasons = append(v.Reasons, res.Reasons...)
+		if res.Text != "" {
+			v.Text = res.Text
+		}
+		if res.Action.severity() > v.Action.severity() {
+			v.Action = res.Action
+		}
+		if v.Action == Reject {
+			break
+		}
+	}
+	return v
+}
+
+// Kind is the kind of a Rule.
+type Kind string
+
+const (
+	// KindWord matches a word however it's cased or accented, or spelled
+	// with leetspeak or Cyrillic and Greek look-alike letters.
+	KindWord Kind = "word"
+	// KindRegex matches a regular expression against the raw text.
+	KindRegex Kind = "regex"
+	// KindLink matches links to a domain or its subdomains.
+	KindLink Kind = "link"
+)
+
+// Rule is one configured moderation rule.
+type Rule struct {
+	Kind    Kind
+	Pattern string
+	Action  Action
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("%s %q", r.Kind, r.Pattern)
+}
+
+// Validate checks that r can be built into a filter.
+func (r Rule) Validate() error {
+	if r.Action == Allow {
+		return errors.New("a rule's action can't be allow")
+	}
+	if _, err := ParseAction(string(r.Action)); err != nil {
+		return err
+	}
+	switch r.Kind {
+	case KindWord:
+		return validateWord(r.Pattern)
+	case KindRegex:
+		return validateRegex(r.Pattern)
+	case KindLink:
+		return validateDomain(r.Pattern)
+	}
+	return fmt.Errorf("unknown rule kind %q", r.Kind)
+}
+
+// Build makes a pipeline of the word rules, then the regex rules, then the
+// link rules.
+func Build(rules []Rule) (*Pipeline, error) {
+	var words, regexes, links []Rule
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r, err)
+		}
+		switch r.Kind {
+		case KindWord:
+			words = append(words, r)
+		case KindRegex:
+			regexes = append(regexes, r)
+		case KindLink:
+			links = append(links, r)
+		}
+	}
+
+	regexFilter, err := NewRegexFilter(regexes)
+	if err != nil {
+		return nil, err
+	}
+	return NewPipeline(NewWordFilter(words), regexFilter, NewLinkFilter(links)), nil
+}
+
+// combine adds a match with action to res.
+func (res *Result) combine(action Action, reason string) {
+	if action.severity() > res.Action.severity() {
+		res.Action = action
+	}
+	res.Reasons = append(res.Reasons, reason)
+}
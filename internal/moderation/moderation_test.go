@@ -0,0 +1,168 @@
+package moderation
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWordFilter(t *testing.T) {
+	f := NewWordFilter([]Rule{
+		{Kind: KindWord, Pattern: "kerfuffle", Action: Mask},
+		{Kind: KindWord, Pattern: "fornax", Action: Mask},
+		{Kind: KindWord, Pattern: "grawlix", Action: Hold},
+	})
+
+	tests := []struct {
+		name   string
+		text   string
+		action Action
+		want   string
+	}{
+		{"Clean", "This is a clean chirp", Allow, ""},
+		{"Exact", "what a kerfuffle", Mask, "what a ****"},
+		{"Punctuation", "Kerfuffle! What a kerfuffle.", Mask, "****! What a ****."},
+		{"Quoted", `"fornax", said (kerfuffle)`, Mask, `"****", said (****)`},
+		{"Accents", "KÉRFUFFLE", Mask, "****"},
+		{"Full width", "ｆｏｒｎａｘ!", Mask, "****!"},
+		{"Stroked letter", "førnax", Mask, "****"},
+		{"Cyrillic look-alikes", "kеrfuffle and FОRNАХ", Mask, "**** and ****"},
+		{"Greek look-alikes", "FΟRΝΑΧ", Mask, "****"},
+		{"Other scripts", "форнакс", Allow, ""},
+		{"Leet", "k3rfuffl3 and f0rn@x", Mask, "**** and ****"},
+		{"Zero width", "kerZWSPfuffle", Mask, "****"},
+		{"Combining mark", "fornaCOMBx", Mask, "****"},
+		{"Inside a word", "kerfufflement", Allow, ""},
+		{"Hold", "grawlix kerfuffle", Hold, "grawlix ****"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := strings.NewReplacer("ZWSP", "\u200b", "COMB", "\u0301").Replace(tt.text)
+			res := f.Check(text)
+			if res.Action != tt.action || res.Text != tt.want {
+				t.Errorf("Check(%q) = %q, %q, want %q, %q", text, res.Action, res.Text, tt.action, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegexFilter(t *testing.T) {
+	f, err := NewRegexFilter([]Rule{
+		{Kind: KindRegex, Pattern: `\d{3}-\d{3}-\d{4}`, Action: Mask},
+		{Kind: KindRegex, Pattern: `(?i)buy now`, Action: Reject},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := f.Check("call 555-123-4567 or 555-765-4321")
+	if res.Action != Mask || res.Text != "call **** or ****" {
+		t.Errorf("Check() = %q, %q, want both numbers masked", res.Action, res.Text)
+	}
+	res = f.Check("BUY NOW at 555-123-4567")
+	if res.Action != Reject || len(res.Reasons) != 2 {
+		t.Errorf("Check() = %q, %v, want reject with both reasons", res.Action, res.Reasons)
+	}
+}
+
+func TestLinkFilter(t *testing.T) {
+	f := NewLinkFilter([]Rule{
+		{Kind: KindLink, Pattern: "spam.example", Action: Reject},
+		{Kind: KindLink, Pattern: "Tracker.example", Action: Mask},
+	})
+
+	tests := []struct {
+		name   string
+		text   string
+		action Action
+		want   string
+	}{
+		{"No links", "hello world.", Allow, ""},
+		{"Other domain", "see https://chirpy.example/about", Allow, ""},
+		{"Blocked", "see https://spam.example/deal", Reject, ""},
+		{"No scheme", "visit spam.example today", Reject, ""},
+		{"Subdomain", "http://WWW.Spam.Example:8080/x", Reject, ""},
+		{"User info", "https://chirpy.example@spam.example/", Reject, ""},
+		{"Lookalike", "notspam.example and spam.example.org", Allow, ""},
+		{"Masked", "pic: https://img.tracker.example/a.png?id=1 nice", Mask, "pic: **** nice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := f.Check(tt.text)
+			if res.Action != tt.action || res.Text != tt.want {
+				t.Errorf("Check(%q) = %q, %q, want %q, %q", tt.text, res.Action, res.Text, tt.action, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	p, err := Build([]Rule{
+		{Kind: KindLink, Pattern: "spam.example", Action: Reject},
+		{Kind: KindWord, Pattern: "fornax", Action: Mask},
+		{Kind: KindRegex, Pattern: `(?i)free money`, Action: Hold},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := p.Check("Fornax, free money!")
+	if v.Action != Hold || v.Text != "****, free money!" {
+		t.Errorf("Check() = %q, %q, want hold with the word masked", v.Action, v.Text)
+	}
+	if !slices.Equal(v.Reasons, []string{`word "fornax"`, `regex "(?i)free money"`}) {
+		t.Errorf("Reasons = %v", v.Reasons)
+	}
+
+	v = p.Check("free money at spam.example")
+	if v.Action != Reject {
+		t.Errorf("Check() = %q, want reject", v.Action)
+	}
+
+	v = p.Check("nothing to see")
+	if v.Action != Allow || v.Text != "nothing to see" || v.Reasons != nil {
+		t.Errorf("Check() = %+v, want the text allowed as is", v)
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		ok   bool
+	}{
+		{"Word", Rule{KindWord, "kerfuffle", Mask}, true},
+		{"Two words", Rule{KindWord, "ker fuffle", Mask}, false},
+		{"Empty word", Rule{KindWord, "!!", Mask}, false},
+		{"Regex", Rule{KindRegex, `b[a4]d`, Hold}, true},
+		{"Bad regex", Rule{KindRegex, `(`, Hold}, false},
+		{"Empty regex", Rule{KindRegex, `x*`, Hold}, false},
+		{"Link", Rule{KindLink, "spam.example", Reject}, true},
+		{"Link with scheme", Rule{KindLink, "https://spam.example", Reject}, false},
+		{"Allow", Rule{KindWord, "kerfuffle", Allow}, false},
+		{"Unknown action", Rule{KindWord, "kerfuffle", "ban"}, false},
+		{"Unknown kind", Rule{"phrase", "kerfuffle", Mask}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate() = %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLoadWordList(t *testing.T) {
+	rules, err := LoadWordList(strings.NewReader("# comment\nkerfuffle\n\n  grawlix hold\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{{KindWord, "kerfuffle", Mask}, {KindWord, "grawlix", Hold}}
+	if !slices.Equal(rules, want) {
+		t.Errorf("LoadWordList() = %v, want %v", rules, want)
+	}
+
+	if _, err := LoadWordList(strings.NewReader("kerfuffle ban\n")); err == nil {
+		t.Error("LoadWordList() accepted an unknown action")
+	}
+}
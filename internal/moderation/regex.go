@@ -0,0 +1,63 @@
+package moderation
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// maxRegexLength keeps rules readable. Go's regexps run in linear time, so
+// a long one isn't a risk, just a sign something's wrong.
+const maxRegexLength = 500
+
+func validateRegex(pattern string) error {
+	if len(pattern) > maxRegexLength {
+		return fmt.Errorf("a regex rule can be at most %d characters", maxRegexLength)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	if re.MatchString("") {
+		return fmt.Errorf("a regex rule can't match an empty string")
+	}
+	return nil
+}
+
+// RegexFilter matches regular expressions against the text as written.
+// They're case sensitive unless they start with (?i).
+type RegexFilter struct {
+	rules   []Rule
+	regexps []*regexp.Regexp
+}
+
+// NewRegexFilter -
+func NewRegexFilter(rules []Rule) (*RegexFilter, error) {
+	f := &RegexFilter{rules: rules}
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r, err)
+		}
+		f.regexps = append(f.regexps, re)
+	}
+	return f, nil
+}
+
+// Check -
+func (f *RegexFilter) Check(text string) Result {
+	res := Result{Action: Allow}
+	masked := text
+	for i, re := range f.regexps {
+		if !re.MatchString(masked) {
+			continue
+		}
+		res.combine(f.rules[i].Action, f.rules[i].String())
+		if f.rules[i].Action == Mask {
+			masked = re.ReplaceAllLiteralString(masked, MaskText)
+		}
+	}
+	if masked != text {
+		res.Text = masked
+	}
+	return res
+}
@@ -0,0 +1,196 @@
+package moderation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultWords are masked when no word list is configured.
+var DefaultWords = []Rule{
+	{Kind: KindWord, Pattern: "kerfuffle", Action: Mask},
+	{Kind: KindWord, Pattern: "sharbert", Action: Mask},
+	{Kind: KindWord, Pattern: "fornax", Action: Mask},
+}
+
+// LoadWordList reads word rules, one per line: the word, then optionally
+// its action, which defaults to mask. Blank lines and lines starting with
+// # are skipped.
+func LoadWordList(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		rule := Rule{Kind: KindWord, Pattern: fields[0], Action: Mask}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected a word and an action", n)
+		}
+		if len(fields) == 2 {
+			rule.Action = Action(fields[1])
+		}
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+func validateWord(word string) error {
+	if len(tokenize(word)) != 1 {
+		return errors.New("a word rule must be a single word")
+	}
+	return nil
+}
+
+// WordFilter matches words of the text against a word list after folding
+// case, accents, full-width forms, Cyrillic and Greek look-alikes and
+// common letter substitutions, so "Kerfuffle!", "KÉRFUFFLE", "kеrfuffle"
+// and "k3rfuffle" all match "kerfuffle".
+type WordFilter struct {
+	words map[string]Rule
+}
+
+// NewWordFilter -
+func NewWordFilter(rules []Rule) *WordFilter {
+	words := map[string]Rule{}
+	for _, r := range rules {
+		key := normalizeWord(r.Pattern)
+		// the stricter rule wins if a word is listed twice
+		if prev, ok := words[key]; !ok || r.Action.severity() > prev.Action.severity() {
+			words[key] = r
+		}
+	}
+	return &WordFilter{words: words}
+}
+
+// Check -
+func (f *WordFilter) Check(text string) Result {
+	res := Result{Action: Allow}
+	var masked strings.Builder
+	last := 0
+	for _, tok := range tokenize(text) {
+		rule, ok := f.words[normalizeWord(text[tok.start:tok.end])]
+		if !ok {
+			continue
+		}
+		res.combine(rule.Action, rule.String())
+		if rule.Action == Mask {
+			masked.WriteString(text[last:tok.start])
+			masked.WriteString(MaskText)
+			last = tok.end
+		}
+	}
+	if last > 0 {
+		masked.WriteString(text[last:])
+		res.Text = masked.String()
+	}
+	return res
+}
+
+type token struct {
+	start, end int
+}
+
+// tokenize splits text into words: runs of letters and digits, with
+// combining marks and invisible characters inside a word kept in it.
+// Punctuation ends a word, except @ and $ inside one, which stand in for
+// letters.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+		case start >= 0 && (unicode.Is(unicode.Mn, r) || isInvisible(r) || r == '@' || r == '$'):
+		default:
+			if start >= 0 {
+				tokens = append(tokens, trimToken(text, start, i))
+				start = -1
+			}
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, trimToken(text, start, len(text)))
+	}
+	return tokens
+}
+
+// trimToken drops trailing @ and $, which aren't part of the word then.
+func trimToken(text string, start, end int) token {
+	for end > start && (text[end-1] == '@' || text[end-1] == '$') {
+		end--
+	}
+	return token{start: start, end: end}
+}
+
+func isInvisible(r rune) bool {
+	switch r {
+	// soft hyphen, zero-width space, non-joiner, joiner, word joiner, BOM
+	case '\u00ad', '\u200b', '\u200c', '\u200d', '\u2060', '\ufeff':
+		return true
+	}
+	return false
+}
+
+var unleet = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's',
+}
+
+// unstroke maps letters whose marks aren't combining ones, so NFKD
+// leaves them whole, to their base letter.
+var unstroke = map[rune]rune{
+	'đ': 'd', 'ħ': 'h', 'ı': 'i', 'ł': 'l', 'ø': 'o', 'ß': 's', 'ŧ': 't',
+}
+
+// confusables maps Cyrillic and Greek letters that look like Latin ones to
+// the lowercase Latin letter, so "kеrfuffle" with a Cyrillic е still
+// matches. Capitals are listed apart, as they don't always look like their
+// lowercase: Greek Ν is an N, ν a v.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'А': 'a', 'В': 'b', 'С': 'c', 'Е': 'e', 'Н': 'h', 'І': 'i', 'Ј': 'j', 'К': 'k',
+	'М': 'm', 'О': 'o', 'Р': 'p', 'Ѕ': 's', 'Т': 't', 'Х': 'x', 'У': 'y',
+	'а': 'a', 'в': 'b', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j',
+	'к': 'k', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'ԝ': 'w', 'х': 'x', 'у': 'y',
+	// Greek
+	'Α': 'a', 'Β': 'b', 'Ε': 'e', 'Ζ': 'z', 'Η': 'h', 'Ι': 'i', 'Κ': 'k', 'Μ': 'm',
+	'Ν': 'n', 'Ο': 'o', 'Ρ': 'p', 'Τ': 't', 'Υ': 'y', 'Χ': 'x',
+	'α': 'a', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'υ': 'u', 'χ': 'x',
+}
+
+// normalizeWord folds a word to the form word lists are matched in. NFKD
+// splits accented letters into a base letter and combining marks, which
+// are dropped, and turns full-width forms like ｆｏｒｎａｘ into plain ones.
+func normalizeWord(word string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(word) {
+		if unicode.Is(unicode.Mn, r) || isInvisible(r) {
+			continue
+		}
+		if latin, ok := confusables[r]; ok {
+			r = latin
+		}
+		r = unicode.ToLower(r)
+		if base, ok := unstroke[r]; ok {
+			r = base
+		}
+		if l, ok := unleet[r]; ok {
+			r = l
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,78 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store for a single instance.
+type MemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]Record
+	lastSweep time.Time
+}
+
+// NewMemoryStore -
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]Record{}}
+}
+
+// Get -
+func (s *MemoryStore) Get(ctx context.Context, key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[key], nil
+}
+
+// AddFailure -
+func (s *MemoryStore) AddFailure(ctx context.Context, key string, prev Record, at, since time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// otherwise every address that ever failed once stays around forever
+	if s.lastSweep.Before(since) {
+		for k, rec := range s.records {
+			if rec.LastFailure.Before(since) {
+				delete(s.records, k)
+			}
+		}
+		s.lastSweep = at
+	}
+
+	rec := s.records[key]
+	if rec != prev {
+		return false, nil
+	}
+	if rec.LastFailure.Before(since) {
+		rec = Record{}
+	}
+	s.records[key] = Record{Failures: rec.Failures + 1, LastFailure: at}
+	return true, nil
+}
+
+// RemoveFailure -
+func (s *MemoryStore) RemoveFailure(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.records[key]; ok && rec.Failures > 0 {
+		rec.Failures--
+		s.records[key] = rec
+	}
+	return nil
+}
+
+// Reset -
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// Clear forgets every key.
+func (s *MemoryStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = map[string]Record{}
+}
@@ -0,0 +1,84 @@
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"Chirpy/internal/database"
+)
+
+// PostgresStore is a Store shared by every instance using the database.
+type PostgresStore struct {
+	db *database.Queries
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewPostgresStore -
+func NewPostgresStore(db *database.Queries) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Get -
+func (s *PostgresStore) Get(ctx context.Context, key string) (Record, error) {
+	row, err := s.db.GetLoginFailures(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Record{}, nil
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Failures: int(row.Failures), LastFailure: row.LastFailureAt}, nil
+}
+
+// AddFailure -
+func (s *PostgresStore) AddFailure(ctx context.Context, key string, prev Record, at, since time.Time) (bool, error) {
+	if err := s.sweep(ctx, at, since); err != nil {
+		return false, err
+	}
+
+	var n int64
+	var err error
+	if prev.LastFailure.IsZero() {
+		n, err = s.db.InsertLoginFailure(ctx, database.InsertLoginFailureParams{
+			Key:           key,
+			LastFailureAt: at,
+		})
+	} else {
+		n, err = s.db.UpdateLoginFailure(ctx, database.UpdateLoginFailureParams{
+			Since:         since,
+			At:            at,
+			Key:           key,
+			PrevFailures:  int32(prev.Failures),
+			PrevFailureAt: prev.LastFailure,
+		})
+	}
+	return n == 1, err
+}
+
+// RemoveFailure -
+func (s *PostgresStore) RemoveFailure(ctx context.Context, key string) error {
+	return s.db.RemoveLoginFailure(ctx, key)
+}
+
+// Reset -
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	return s.db.DeleteLoginFailures(ctx, key)
+}
+
+// sweep deletes forgotten records, at most once a window per instance.
+func (s *PostgresStore) sweep(ctx context.Context, at, since time.Time) error {
+	s.mu.Lock()
+	if !s.lastSweep.Before(since) {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastSweep = at
+	s.mu.Unlock()
+
+	return s.db.DeleteStaleLoginFailures(ctx, since)
+}
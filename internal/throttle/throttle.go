@@ -0,0 +1,139 @@
+// Package throttle slows down repeated failures, such as password guesses,
+// with an exponential backoff per key.
+package throttle
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Record is the failure history of one key.
+type Record struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store keeps failure records. Use a shared store when running more than
+// one instance, or each instance gets its own budget.
+type Store interface {
+	// Get returns the record for key, or a zero Record if there is none.
+	Get(ctx context.Context, key string) (Record, error)
+	// AddFailure counts a failure at time at if the record of key is still
+	// prev, and reports whether it did. Failures from before since are
+	// forgotten first. Comparing makes it safe for concurrent callers: only
+	// one of those that read the same record gets to count on it.
+	AddFailure(ctx context.Context, key string, prev Record, at, since time.Time) (bool, error)
+	// RemoveFailure takes back one failure of key.
+	RemoveFailure(ctx context.Context, key string) error
+	// Reset forgets key.
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy says how hard to throttle.
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before any delay.
+	FreeAttempts int
+	// BaseDelay is the delay after the first failure past FreeAttempts.
+	// It doubles with every further failure.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay. Reaching it amounts to a temporary lockout.
+	MaxDelay time.Duration
+	// Window is how long a failure is remembered.
+	Window time.Duration
+}
+
+// Delay is how long to wait after the given number of failures.
+func (p Policy) Delay(failures int) time.Duration {
+	over := failures - p.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < over; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// Limiter applies a Policy to keys in a Store.
+type Limiter struct {
+	store  Store
+	policy Policy
+	prefix string
+	now    func() time.Time
+}
+
+// NewLimiter makes a limiter. prefix namespaces its keys, so limiters
+// with different policies can share a store.
+func NewLimiter(store Store, prefix string, policy Policy) *Limiter {
+	return &Limiter{
+		store:  store,
+		policy: policy,
+		prefix: prefix,
+		now:    time.Now,
+	}
+}
+
+// Check returns how long key has to wait before its next attempt, or 0
+// if it may go ahead.
+func (l *Limiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	rec, err := l.store.Get(ctx, l.prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	return l.retryAfter(rec), nil
+}
+
+// maxAttemptTries bounds how often Attempt retries when concurrent
+// attempts keep changing the record under it.
+const maxAttemptTries = 10
+
+// Attempt returns how long key has to wait before its next attempt, or 0
+// if it may go ahead, in which case the attempt is counted as a failure
+// before it's made, so concurrent attempts can't all get past the check.
+// Attempts that have to wait aren't counted, so waiting out the delay is
+// enough to try again. Refund or Reset the attempt if it succeeds.
+func (l *Limiter) Attempt(ctx context.Context, key string) (time.Duration, error) {
+	for range maxAttemptTries {
+		rec, err := l.store.Get(ctx, l.prefix+key)
+		if err != nil {
+			return 0, err
+		}
+		if wait := l.retryAfter(rec); wait > 0 {
+			return wait, nil
+		}
+		now := l.now().UTC()
+		added, err := l.store.AddFailure(ctx, l.prefix+key, rec, now, now.Add(-l.policy.Window))
+		if err != nil {
+			return 0, err
+		}
+		if added {
+			return 0, nil
+		}
+	}
+	return 0, errors.New("throttle: too many concurrent attempts")
+}
+
+// Refund takes back an attempt of key that succeeded, without forgetting
+// its other failures.
+func (l *Limiter) Refund(ctx context.Context, key string) error {
+	return l.store.RemoveFailure(ctx, l.prefix+key)
+}
+
+// Reset clears the failures of key, e.g. after a successful attempt.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, l.prefix+key)
+}
+
+func (l *Limiter) retryAfter(rec Record) time.Duration {
+	now := l.now().UTC()
+	if rec.Failures == 0 || now.Sub(rec.LastFailure) > l.policy.Window {
+		return 0
+	}
+	wait := rec.LastFailure.Add(l.policy.Delay(rec.Failures)).Sub(now)
+	return max(wait, 0)
+}
@@ -0,0 +1,168 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     10 * time.Second,
+	Window:       time.Hour,
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := testPolicy.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(NewMemoryStore(), "test:", testPolicy)
+	l.now = func() time.Time { return now }
+
+	// the free attempts, then one more after them
+	for range 4 {
+		if wait, _ := l.Attempt(ctx, "alice"); wait != 0 {
+			t.Fatalf("Attempt() within free attempts = %v, want 0", wait)
+		}
+	}
+	if wait, _ := l.Attempt(ctx, "alice"); wait != time.Second {
+		t.Errorf("Attempt() = %v, want 1s", wait)
+	}
+	if wait, _ := l.Check(ctx, "bob"); wait != 0 {
+		t.Errorf("Check() of another key = %v, want 0", wait)
+	}
+
+	// throttled attempts aren't counted, so they don't push the wait back
+	now = now.Add(500 * time.Millisecond)
+	for range 5 {
+		if wait, _ := l.Attempt(ctx, "alice"); wait != 500*time.Millisecond {
+			t.Fatalf("Attempt() while throttled = %v, want 500ms", wait)
+		}
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if wait, _ := l.Attempt(ctx, "alice"); wait != 0 {
+		t.Errorf("Attempt() after waiting out the delay = %v, want 0", wait)
+	}
+	if wait, _ := l.Check(ctx, "alice"); wait != 2*time.Second {
+		t.Errorf("Check() = %v, want 2s", wait)
+	}
+	l.Refund(ctx, "alice")
+	if wait, _ := l.Check(ctx, "alice"); wait != time.Second {
+		t.Errorf("Check() after Refund() = %v, want 1s", wait)
+	}
+
+	// failures are forgotten after the window
+	now = now.Add(2 * time.Hour)
+	if wait, _ := l.Attempt(ctx, "alice"); wait != 0 {
+		t.Errorf("Attempt() after the window = %v, want 0", wait)
+	}
+
+	for range 10 {
+		l.Attempt(ctx, "alice")
+	}
+	l.Reset(ctx, "alice")
+	if wait, _ := l.Check(ctx, "alice"); wait != 0 {
+		t.Errorf("Check() after Reset() = %v, want 0", wait)
+	}
+}
+
+func TestLimiterRetryAfter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(NewMemoryStore(), "test:", testPolicy)
+	l.now = func() time.Time { return now }
+
+	// a client that keeps failing but always waits as long as it's told
+	for i := range 10 {
+		wait, err := l.Attempt(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait > 0 {
+			t.Fatalf("attempt %d after waiting out Retry-After = %v, want 0", i+1, wait)
+		}
+		if wait, _ = l.Check(ctx, "alice"); wait > 0 {
+			now = now.Add(wait)
+		}
+	}
+}
+
+func TestLimiterConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(NewMemoryStore(), "test:", testPolicy)
+	l.now = func() time.Time { return now }
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := l.Attempt(ctx, "alice")
+			if err != nil {
+				t.Error(err)
+			}
+			if wait == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := int32(testPolicy.FreeAttempts + 1)
+	if got := allowed.Load(); got != want {
+		t.Errorf("%d of 50 concurrent attempts went ahead, want %d", got, want)
+	}
+}
+
+func TestLimiterPrefix(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	strict := NewLimiter(store, "strict:", Policy{FreeAttempts: 0, BaseDelay: time.Minute, MaxDelay: time.Minute, Window: time.Hour})
+	lax := NewLimiter(store, "lax:", testPolicy)
+
+	strict.Attempt(ctx, "key")
+	strict.Attempt(ctx, "key")
+	if wait, _ := lax.Check(ctx, "key"); wait != 0 {
+		t.Errorf("Check() = %v, limiters with different prefixes share keys", wait)
+	}
+}
+
+func TestMemoryStoreClear(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	limiter := NewLimiter(store, "", Policy{FreeAttempts: 0, BaseDelay: time.Minute, MaxDelay: time.Minute, Window: time.Hour})
+
+	limiter.Attempt(ctx, "a")
+	limiter.Attempt(ctx, "b")
+	store.Clear()
+	for _, key := range []string{"a", "b"} {
+		if wait, _ := limiter.Check(ctx, key); wait != 0 {
+			t.Errorf("Check(%q) = %v after Clear, want 0", key, wait)
+		}
+	}
+}
@@ -32,3 +32,21 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(dat)
 }
+
+// fieldError says what is wrong with one field of a request body.
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func respondWithFieldErrors(w http.ResponseWriter, errs []fieldError) {
+	type errorResponse struct {
+		Error  string       `json:"error"`
+		Fields []fieldError `json:"fields"`
+	}
+	respondWithJSON(w, http.StatusBadRequest, errorResponse{
+		Error:  "Invalid parameters",
+		Fields: errs,
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	mailWorkers   = 4
+	mailQueueSize = 100
+	// mailTimeout bounds one queued job, talking to the mail server included
+	mailTimeout = 30 * time.Second
+)
+
+// mailJob prepares and sends mail after the response went out.
+type mailJob func(ctx context.Context)
+
+// runMailWorker runs queued mail jobs one at a time until ctx is done.
+func (cfg *apiConfig) runMailWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-cfg.mailQueue:
+			jobCtx, cancel := context.WithTimeout(ctx, mailTimeout)
+			job(jobCtx)
+			cancel()
+		}
+	}
+}
+
+// queueMail hands job to the mail workers and reports whether there was
+// room for it. A full queue drops the job, so a flood of requests or a
+// stuck mail server can't pile up goroutines.
+func (cfg *apiConfig) queueMail(job mailJob) bool {
+	select {
+	case cfg.mailQueue <- job:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,24 +1,50 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"sync/atomic"
 
+	"Chirpy/internal/auth"
 	"Chirpy/internal/database"
+	"Chirpy/internal/entitlements"
+	"Chirpy/internal/mailer"
+	"Chirpy/internal/throttle"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	dbConn         *sql.DB
 	db             *database.Queries
 	platform       string
 	jwtSecret      string
-	polkaKey       string
+	keyring        *auth.Keyring
+	passwordHasher auth.PasswordHasher
+	// dummyPasswordHash is checked for unknown emails at login
+	dummyPasswordHash string
+	passwordPolicy    auth.PasswordPolicy
+	plans             *entitlements.Catalog
+	moderator         *moderator
+	polkaSecrets      []string
+	mailer            mailer.Mailer
+	baseURL           string
+
+	requireEmailVerification  bool
+	throttleStore             throttle.Store
+	loginAccountLimiter       *throttle.Limiter
+	loginIPLimiter            *throttle.Limiter
+	passwordResetEmailLimiter *throttle.Limiter
+	passwordResetIPLimiter    *throttle.Limiter
+	emailVerificationLimiter  *throttle.Limiter
+	mailQueue                 chan mailJob
 }
 
 func main() {
@@ -38,9 +64,75 @@ func main() {
 	if jwtSecret == "" {
 		log.Fatal("JWT_SECRET environment variable is not set")
 	}
-	polkaKey := os.Getenv("POLKA_KEY")
-	if polkaKey == "" {
-		log.Fatal("POLKA_KEY environment variable is not set")
+	// without JWT_KEYS_DIR access tokens are HS256 signed with JWT_SECRET.
+	// With it JWT_SECRET only verifies them if JWT_ACCEPT_LEGACY_HMAC is set.
+	keyring, err := loadKeyring(jwtSecret)
+	if err != nil {
+		log.Fatalf("Error loading JWT keys: %s", err)
+	}
+	// several comma separated secrets are accepted while rotating
+	polkaSecrets := strings.FieldsFunc(os.Getenv("POLKA_WEBHOOK_SECRETS"), func(r rune) bool { return r == ',' })
+	// POLKA_KEY is the old name, accepted until the next release
+	if len(polkaSecrets) == 0 && os.Getenv("POLKA_KEY") != "" {
+		log.Print("POLKA_KEY is deprecated, set POLKA_WEBHOOK_SECRETS instead")
+		polkaSecrets = []string{os.Getenv("POLKA_KEY")}
+	}
+	if len(polkaSecrets) == 0 {
+		log.Fatal("POLKA_WEBHOOK_SECRETS environment variable is not set")
+	}
+
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+	requireEmailVerification := os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+
+	var passwordHasher auth.PasswordHasher
+	maxPasswordLength := 128
+	switch os.Getenv("PASSWORD_HASHER") {
+	case "", "argon2id":
+		passwordHasher = auth.Argon2idHasher{Params: auth.DefaultArgon2idParams}
+	case "bcrypt":
+		passwordHasher = auth.BcryptHasher{Cost: bcrypt.DefaultCost}
+		maxPasswordLength = 72
+	default:
+		log.Fatalf("Unknown PASSWORD_HASHER: %s", os.Getenv("PASSWORD_HASHER"))
+	}
+	dummyPasswordHash, err := passwordHasher.Hash("not the password of any account")
+	if err != nil {
+		log.Fatalf("Error hashing the dummy password: %s", err)
+	}
+	passwordPolicy, err := loadPasswordPolicy(maxPasswordLength)
+	if err != nil {
+		log.Fatalf("Error loading password policy: %s", err)
+	}
+
+	plans, err := loadPlans()
+	if err != nil {
+		log.Fatalf("Error loading entitlements: %s", err)
+	}
+
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "chirpy@localhost"
+	}
+	var mail mailer.Mailer
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		// MAIL_DIR collects messages as .eml files, otherwise they are logged
+		mail = &mailer.LogMailer{Dir: os.Getenv("MAIL_DIR"), From: mailFrom}
+	case "smtp":
+		smtpHost := os.Getenv("SMTP_HOST")
+		if smtpHost == "" {
+			log.Fatal("SMTP_HOST must be set when MAILER is smtp")
+		}
+		smtpPort := os.Getenv("SMTP_PORT")
+		if smtpPort == "" {
+			smtpPort = "587"
+		}
+		mail = mailer.NewSMTPMailer(smtpHost, smtpPort, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), mailFrom)
+	default:
+		log.Fatalf("Unknown MAILER: %s", os.Getenv("MAILER"))
 	}
 
 	dbConn, err := sql.Open("postgres", dbURL)
@@ -49,12 +141,46 @@ func main() {
 	}
 	dbQueries := database.New(dbConn)
 
+	moderator, err := loadModerator(dbQueries)
+	if err != nil {
+		log.Fatalf("Error loading moderation word list: %s", err)
+	}
+
+	var throttleStore throttle.Store
+	switch os.Getenv("LOGIN_THROTTLE_STORE") {
+	case "", "memory":
+		throttleStore = throttle.NewMemoryStore()
+	case "postgres":
+		// needed when several instances share the load
+		throttleStore = throttle.NewPostgresStore(dbQueries)
+	default:
+		log.Fatalf("Unknown LOGIN_THROTTLE_STORE: %s", os.Getenv("LOGIN_THROTTLE_STORE"))
+	}
+
 	apiCfg := apiConfig{
-		fileserverHits: atomic.Int32{},
-		db:             dbQueries,
-		platform:       platform,
-		jwtSecret:      jwtSecret,
-		polkaKey:      polkaKey,
+		fileserverHits:    atomic.Int32{},
+		dbConn:            dbConn,
+		db:                dbQueries,
+		platform:          platform,
+		jwtSecret:         jwtSecret,
+		keyring:           keyring,
+		passwordHasher:    passwordHasher,
+		dummyPasswordHash: dummyPasswordHash,
+		passwordPolicy:    passwordPolicy,
+		plans:             plans,
+		moderator:         moderator,
+		polkaSecrets:      polkaSecrets,
+		mailer:            mail,
+		baseURL:           baseURL,
+
+		requireEmailVerification:  requireEmailVerification,
+		throttleStore:             throttleStore,
+		loginAccountLimiter:       throttle.NewLimiter(throttleStore, "login-account:", loginAccountPolicy),
+		loginIPLimiter:            throttle.NewLimiter(throttleStore, "login-ip:", loginIPPolicy),
+		passwordResetEmailLimiter: throttle.NewLimiter(throttleStore, "password-reset-email:", passwordResetEmailPolicy),
+		passwordResetIPLimiter:    throttle.NewLimiter(throttleStore, "password-reset-ip:", passwordResetIPPolicy),
+		emailVerificationLimiter:  throttle.NewLimiter(throttleStore, "email-verification:", emailVerificationPolicy),
+		mailQueue:                 make(chan mailJob, mailQueueSize),
 	}
 
 	mux := http.NewServeMux()
@@ -64,21 +190,74 @@ func main() {
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
 
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
+	mux.HandleFunc("POST /api/mfa/totp", apiCfg.handlerTOTPEnroll)
+	mux.HandleFunc("POST /api/mfa/totp/confirm", apiCfg.handlerTOTPConfirm)
+	mux.HandleFunc("DELETE /api/mfa/totp", apiCfg.handlerTOTPDisable)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
+	mux.HandleFunc("GET /api/sessions", apiCfg.handlerSessionsGet)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.handlerSessionsDelete)
+	mux.HandleFunc("POST /api/sessions/revoke-all", apiCfg.handlerSessionsRevokeAll)
+	mux.HandleFunc("POST /api/tokens", apiCfg.handlerTokensCreate)
+	mux.HandleFunc("GET /api/tokens", apiCfg.handlerTokensGet)
+	mux.HandleFunc("DELETE /api/tokens/{tokenID}", apiCfg.handlerTokensDelete)
+	mux.HandleFunc("POST /api/oauth/clients", apiCfg.handlerOAuthClientsCreate)
+	mux.HandleFunc("GET /api/oauth/clients", apiCfg.handlerOAuthClientsGet)
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", apiCfg.handlerOAuthClientsDelete)
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.handlerOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", apiCfg.handlerOAuthAuthorizeDecision)
+	mux.HandleFunc("POST /oauth/token", apiCfg.handlerOAuthToken)
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerPasswordReset)
 
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsersCreate)
 	mux.HandleFunc("PUT /api/users", apiCfg.handlerUsersUpdate)
+	mux.HandleFunc("PATCH /api/users", apiCfg.handlerUsersPatch)
+	mux.HandleFunc("GET /api/users/{handle}", apiCfg.handlerUsersProfileGet)
+	mux.HandleFunc("POST /api/email/verification", apiCfg.handlerEmailVerificationSend)
+	mux.HandleFunc("GET /api/email/verify", apiCfg.handlerEmailVerify)
+
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.handlerFollow)
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.handlerUnfollow)
+	mux.HandleFunc("GET /api/users/{userID}/followers", apiCfg.handlerFollowersGet)
+	mux.HandleFunc("GET /api/users/{userID}/following", apiCfg.handlerFollowingGet)
+
+	mux.HandleFunc("GET /api/timeline", apiCfg.handlerTimeline)
+	mux.HandleFunc("GET /api/entitlements", apiCfg.handlerEntitlementsGet)
 
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirpsCreate)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerChirpsRetrieve)
+	mux.HandleFunc("GET /api/chirps/search", apiCfg.handlerChirpsSearch)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerChirpsGet)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.handlerChirpsUpdate)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", apiCfg.handlerChirpRevisionsGet)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.handlerChirpsThread)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/like", apiCfg.handlerChirpsLike)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", apiCfg.handlerChirpsUnlike)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/rechirp", apiCfg.handlerChirpsRechirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/rechirp", apiCfg.handlerChirpsUndoRechirp)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/quote", apiCfg.handlerChirpsQuote)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolka)
 
 	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
 	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
+	mux.HandleFunc("GET /admin/webhooks/events", apiCfg.handlerWebhookEventsGet)
+	mux.HandleFunc("POST /admin/webhooks/events/{eventID}/replay", apiCfg.handlerWebhookEventsReplay)
+	mux.HandleFunc("GET /admin/moderation/rules", apiCfg.handlerModerationRulesGet)
+	mux.HandleFunc("POST /admin/moderation/rules", apiCfg.handlerModerationRulesCreate)
+	mux.HandleFunc("DELETE /admin/moderation/rules/{ruleID}", apiCfg.handlerModerationRulesDelete)
+	mux.HandleFunc("GET /admin/moderation/queue", apiCfg.handlerModerationQueueGet)
+	mux.HandleFunc("POST /admin/moderation/queue/{heldID}/approve", apiCfg.handlerModerationQueueApprove)
+	mux.HandleFunc("POST /admin/moderation/queue/{heldID}/reject", apiCfg.handlerModerationQueueReject)
+
+	go apiCfg.runSubscriptionSweeper(context.Background(), subscriptionSweepInterval)
+	for range mailWorkers {
+		go apiCfg.runMailWorker(context.Background())
+	}
 
 	srv := &http.Server{
 		Addr:    ":" + port,
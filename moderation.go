@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"Chirpy/internal/database"
+	"Chirpy/internal/moderation"
+)
+
+// moderationRefreshInterval is how long the moderation rules are cached.
+// Changes through the admin API apply at once on the instance that made
+// them and within this long on the others.
+const moderationRefreshInterval = time.Minute
+
+// moderator builds the moderation pipeline from the word list and the
+// rules in the database.
+type moderator struct {
+	db    *database.Queries
+	words []moderation.Rule
+
+	mu        sync.Mutex
+	pipeline  *moderation.Pipeline
+	loadedAt  time.Time
+	reloading bool
+	// generation counts invalidations, so a reload that raced with one
+	// isn't taken as fresh
+	generation int
+}
+
+// loadModerator reads the word list from MODERATION_WORDS_FILE if it's set.
+func loadModerator(db *database.Queries) (*moderator, error) {
+	m := &moderator{db: db, words: moderation.DefaultWords}
+	path := os.Getenv("MODERATION_WORDS_FILE")
+	if path == "" {
+		return m, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m.words, err = moderation.LoadWordList(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Pipeline returns the current pipeline. If the rules can't be reloaded
+// the previous pipeline is kept. The rules are read without holding the
+// lock, and while one caller reloads them the others keep using the
+// previous pipeline, so a slow database doesn't hold up every post.
+func (m *moderator) Pipeline(ctx context.Context) (*moderation.Pipeline, error) {
+	m.mu.Lock()
+	pipeline := m.pipeline
+	fresh := pipeline != nil && time.Since(m.loadedAt) < moderationRefreshInterval
+	if fresh || (pipeline != nil && m.reloading) {
+		m.mu.Unlock()
+		return pipeline, nil
+	}
+	m.reloading = true
+	generation := m.generation
+	m.mu.Unlock()
+
+	built, err := m.build(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reloading = false
+	if err != nil {
+		if m.pipeline != nil {
+			log.Printf("Couldn't reload moderation rules: %s", err)
+			return m.pipeline, nil
+		}
+		return nil, err
+	}
+	m.pipeline = built
+	if m.generation == generation {
+		m.loadedAt = time.Now()
+	}
+	return built, nil
+}
+
+// build makes a pipeline of the word list and the stored rules. A stored
+// rule that's invalid, say one added to the table by hand, is
+// skipped, or it would stop every chirp from being posted.
+func (m *moderator) build(ctx context.Context) (*moderation.Pipeline, error) {
+	dbRules, err := m.db.ListModerationRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]moderation.Rule, 0, len(m.words)+len(dbRules))
+	rules = append(rules, m.words...)
+	for _, r := range dbRules {
+		rule := databaseRuleToRule(r)
+		if err := rule.Validate(); err != nil {
+			log.Printf("Skipping moderation rule %s: %s", r.ID, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return moderation.Build(rules)
+}
+
+// Invalidate makes the next Pipeline call reload the rules.
+func (m *moderator) Invalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loadedAt = time.Time{}
+	m.generation++
+}
+
+func databaseRuleToRule(r database.ModerationRule) moderation.Rule {
+	return moderation.Rule{
+		Kind:    moderation.Kind(r.Kind),
+		Pattern: r.Pattern,
+		Action:  moderation.Action(r.Action),
+	}
+}
+
+// moderateChirp checks a chirp against the author's maximum length and the
+// moderation rules, and returns the verdict with bad words masked. It
+// responds itself if the chirp is too long or rejected.
+func (cfg *apiConfig) moderateChirp(w http.ResponseWriter, r *http.Request, body string, maxLength int) (moderation.Verdict, bool) {
+	if len(body) > maxLength {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
+		return moderation.Verdict{}, false
+	}
+
+	pipeline, err := cfg.moderator.Pipeline(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't moderate chirp", err)
+		return moderation.Verdict{}, false
+	}
+
+	verdict := pipeline.Check(body)
+	if verdict.Action == moderation.Reject {
+		// which rule matched isn't said, so rules can't be probed
+		respondWithError(w, http.StatusBadRequest, "Chirp was rejected by moderation",
+			fmt.Errorf("rejected chirp: %s", strings.Join(verdict.Reasons, ", ")))
+		return verdict, false
+	}
+	return verdict, true
+}
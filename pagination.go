@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pageCursor is an opaque position in a list ordered by (created_at, id).
+// A backward cursor walks towards the beginning of the requested ordering.
+type pageCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+	Backward  bool      `json:"b,omitempty"`
+}
+
+func (c pageCursor) encode() string {
+	dat, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func decodePageCursor(s string) (pageCursor, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageCursor{}, errors.New("Invalid cursor")
+	}
+	cursor := pageCursor{}
+	if err := json.Unmarshal(dat, &cursor); err != nil || cursor.ID == uuid.Nil {
+		return pageCursor{}, errors.New("Invalid cursor")
+	}
+	return cursor, nil
+}
+
+type pageParams struct {
+	Limit  int
+	Cursor *pageCursor
+}
+
+func parsePageLimit(query url.Values) (int, error) {
+	s := query.Get("limit")
+	if s == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+	}
+	return limit, nil
+}
+
+func parsePageParams(query url.Values) (pageParams, error) {
+	limit, err := parsePageLimit(query)
+	if err != nil {
+		return pageParams{}, err
+	}
+	params := pageParams{Limit: limit}
+
+	if s := query.Get("cursor"); s != "" {
+		cursor, err := decodePageCursor(s)
+		if err != nil {
+			return pageParams{}, err
+		}
+		params.Cursor = &cursor
+	}
+	return params, nil
+}
+
+// backward reports whether rows must be scanned against the requested
+// ordering, i.e. when the client follows a prev cursor.
+func (p pageParams) backward() bool {
+	return p.Cursor != nil && p.Cursor.Backward
+}
+
+// paginate trims items, fetched with p.Limit+1 rows in scan order, to one
+// page in the requested ordering and returns the cursors around that page.
+func paginate[T any](items []T, p pageParams, key func(T) (time.Time, uuid.UUID)) (page []T, next, prev string) {
+	hasMore := len(items) > p.Limit
+	if hasMore {
+		items = items[:p.Limit]
+	}
+	if p.backward() {
+		slices.Reverse(items)
+	}
+	if len(items) == 0 {
+		return items, "", ""
+	}
+
+	cursorAt := func(item T, backward bool) string {
+		createdAt, id := key(item)
+		return pageCursor{CreatedAt: createdAt, ID: id, Backward: backward}.encode()
+	}
+	if hasMore || p.backward() {
+		next = cursorAt(items[len(items)-1], false)
+	}
+	if (hasMore && p.backward()) || (p.Cursor != nil && !p.backward()) {
+		prev = cursorAt(items[0], true)
+	}
+	return items, next, prev
+}
+
+// setLinkHeader advertises the next and prev pages as RFC 8288 links,
+// keeping every other query parameter of the current request.
+func setLinkHeader(w http.ResponseWriter, r *http.Request, next, prev string) {
+	links := []string{}
+	for _, link := range []struct{ rel, cursor string }{{"next", next}, {"prev", prev}} {
+		if link.cursor == "" {
+			continue
+		}
+		query := r.URL.Query()
+		query.Set("cursor", link.cursor)
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		links = append(links, "<"+u.String()+`>; rel="`+link.rel+`"`)
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"Chirpy/internal/auth"
+)
+
+// loadPasswordPolicy reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH,
+// PASSWORD_MIN_STRENGTH and BREACHED_PASSWORDS_FILE. maxLength is the
+// most the password hasher takes.
+func loadPasswordPolicy(maxLength int) (auth.PasswordPolicy, error) {
+	policy := auth.PasswordPolicy{
+		MinLength:   8,
+		MaxLength:   maxLength,
+		MinStrength: 2,
+	}
+	for name, value := range map[string]*int{
+		"PASSWORD_MIN_LENGTH":   &policy.MinLength,
+		"PASSWORD_MAX_LENGTH":   &policy.MaxLength,
+		"PASSWORD_MIN_STRENGTH": &policy.MinStrength,
+	} {
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return policy, fmt.Errorf("%s: %w", name, err)
+		}
+		*value = n
+	}
+
+	if policy.MinLength < 1 {
+		return policy, fmt.Errorf("PASSWORD_MIN_LENGTH must be at least 1")
+	}
+	if policy.MaxLength > maxLength || policy.MaxLength < policy.MinLength {
+		return policy, fmt.Errorf("PASSWORD_MAX_LENGTH must be between PASSWORD_MIN_LENGTH and %d", maxLength)
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := auth.OpenBreachedFile(path)
+		if err != nil {
+			return policy, err
+		}
+		policy.Breached = breached
+	}
+	return policy, nil
+}
+
+// checkPassword responds with 400 and returns false if password breaks
+// the password policy. userInputs are the user's email, handle and so on.
+func (cfg *apiConfig) checkPassword(w http.ResponseWriter, password string, userInputs ...string) bool {
+	violations, err := cfg.passwordPolicy.Check(password, userInputs...)
+	if err != nil {
+		// the breached list is a second line of defence, don't lock
+		// everyone out when it can't be read
+		log.Printf("Couldn't check password against breached passwords: %s", err)
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	fieldErrors := make([]fieldError, 0, len(violations))
+	for _, v := range violations {
+		fieldErrors = append(fieldErrors, fieldError{
+			Field:   "password",
+			Code:    v.Code,
+			Message: v.Message,
+		})
+	}
+	respondWithFieldErrors(w, fieldErrors)
+	return false
+}
@@ -1,6 +1,10 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
+
+	"Chirpy/internal/throttle"
+)
 
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 	if cfg.platform != "dev" {
@@ -16,6 +20,10 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Failed to reset the database: " + err.Error()))
 		return
 	}
+	// a Postgres store was cleared with the database
+	if store, ok := cfg.throttleStore.(*throttle.MemoryStore); ok {
+		store.Clear()
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Hits reset to 0 and database reset to initial state."))
 }
@@ -0,0 +1,17 @@
+-- name: AddChirpAttempt :one
+INSERT INTO chirp_rate_limits (user_id, window_start, attempts)
+VALUES (
+    sqlc.arg('user_id'),
+    sqlc.arg('at'),
+    1
+)
+ON CONFLICT (user_id) DO UPDATE
+SET attempts = CASE
+        WHEN chirp_rate_limits.window_start <= sqlc.arg('since') THEN 1
+        ELSE chirp_rate_limits.attempts + 1
+    END,
+    window_start = CASE
+        WHEN chirp_rate_limits.window_start <= sqlc.arg('since') THEN EXCLUDED.window_start
+        ELSE chirp_rate_limits.window_start
+    END
+RETURNING *;
@@ -1,31 +1,178 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to)
 VALUES (
         gen_random_uuid(),
         NOW(),
         NOW(),
         $1,
-        $2
+        $2,
+        $3
     )
 RETURNING *;
 
--- name: GetChirps :many
+-- name: CreatePlainRechirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, rechirp_of, is_plain_rechirp)
+VALUES (
+        gen_random_uuid(),
+        NOW(),
+        NOW(),
+        '',
+        $1,
+        $2,
+        true
+    )
+ON CONFLICT (user_id, rechirp_of) WHERE is_plain_rechirp AND deleted_at IS NULL DO NOTHING
+RETURNING *;
+
+-- name: CreateQuoteChirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, rechirp_of)
+VALUES (
+        gen_random_uuid(),
+        NOW(),
+        NOW(),
+        $1,
+        $2,
+        $3
+    )
+RETURNING *;
+
+-- name: GetChirp :one
 SELECT *
 FROM chirps
-ORDER BY created_at ASC;
+WHERE id = $1
+AND deleted_at IS NULL;
 
--- name: GetChirp :one
+-- name: GetChirpForUpdate :one
 SELECT *
 FROM chirps
-WHERE id = $1;
+WHERE id = $1
+AND deleted_at IS NULL
+FOR UPDATE;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $1,
+    updated_at = NOW()
+WHERE id = $2
+RETURNING *;
 
 -- name: DeleteChirp :exec
 delete from chirps
 WHERE id = $1;
 
+-- name: TombstoneChirp :exec
+UPDATE chirps
+SET body = '',
+    deleted_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: DeletePlainRechirp :execrows
+DELETE FROM chirps
+WHERE user_id = $1
+AND rechirp_of = $2
+AND is_plain_rechirp
+AND deleted_at IS NULL;
+
+-- name: DeletePlainRechirps :exec
+DELETE FROM chirps
+WHERE rechirp_of = $1
+AND is_plain_rechirp
+AND deleted_at IS NULL;
+
+-- name: ChirpHasDependents :one
+SELECT EXISTS (
+        SELECT 1
+        FROM chirps
+        WHERE in_reply_to = sqlc.arg('chirp_id')::uuid
+        OR (
+            rechirp_of = sqlc.arg('chirp_id')::uuid
+            AND NOT (is_plain_rechirp AND deleted_at IS NULL)
+        )
+    );
+
+-- name: GetChirpsByIDs :many
+SELECT *
+FROM chirps
+WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, 0 AS depth
+    FROM chirps
+    WHERE chirps.id = $1
+    UNION ALL
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id,
+        chirps.in_reply_to, chirps.deleted_at, ancestors.depth + 1
+    FROM chirps
+    JOIN ancestors ON chirps.id = ancestors.in_reply_to
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, depth
+FROM ancestors
+ORDER BY depth DESC;
+
+-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, 1 AS depth
+    FROM chirps
+    WHERE chirps.in_reply_to = $1
+    UNION ALL
+    SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id,
+        chirps.in_reply_to, chirps.deleted_at, descendants.depth + 1
+    FROM chirps
+    JOIN descendants ON chirps.in_reply_to = descendants.id
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, depth
+FROM descendants
+ORDER BY depth, created_at, id;
 
--- name: GetChirpsByAuthor :many
+-- name: ListChirpsAsc :many
 SELECT *
 FROM chirps
-where user_id = $1
-ORDER BY created_at ASC;
\ No newline at end of file
+WHERE deleted_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (
+        sqlc.narg('cursor_created_at')::timestamp IS NULL
+        OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+    )
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('limit');
+
+-- name: ListChirpsDesc :many
+SELECT *
+FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (
+        sqlc.narg('cursor_created_at')::timestamp IS NULL
+        OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+    )
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: SearchChirps :many
+WITH matches AS (
+    SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of,
+        to_tsquery('english', sqlc.arg('query')) AS query,
+        ts_rank(search_vector, to_tsquery('english', sqlc.arg('query'))) AS rank
+    FROM chirps
+    WHERE search_vector @@ to_tsquery('english', sqlc.arg('query'))
+    AND deleted_at IS NULL
+    AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+    ORDER BY rank DESC, created_at DESC, id DESC
+    LIMIT sqlc.arg('limit')
+)
+SELECT id, created_at, updated_at, body, user_id, in_reply_to, deleted_at, rechirp_of, rank,
+    CASE
+        WHEN sqlc.arg('highlight')::boolean THEN ts_headline(
+            'english',
+            -- the body is escaped first, so the highlighting is the only markup
+            replace(replace(replace(replace(replace(
+                body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;'),
+            query,
+            'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'
+        )
+        ELSE ''
+    END::text AS snippet
+FROM matches
+ORDER BY rank DESC, created_at DESC, id DESC;
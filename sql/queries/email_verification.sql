@@ -0,0 +1,18 @@
+-- name: CreateEmailVerificationToken :one
+INSERT INTO email_verification_tokens (id, created_at, user_id, email, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: UseEmailVerificationToken :one
+UPDATE email_verification_tokens
+SET used_at = NOW()
+WHERE id = $1
+AND used_at IS NULL
+AND expires_at > NOW()
+RETURNING *;
@@ -0,0 +1,32 @@
+-- name: GetLoginFailures :one
+SELECT * FROM login_failures
+WHERE key = $1;
+
+-- name: InsertLoginFailure :execrows
+INSERT INTO login_failures (key, failures, last_failure_at)
+VALUES ($1, 1, $2)
+ON CONFLICT (key) DO NOTHING;
+
+-- name: UpdateLoginFailure :execrows
+UPDATE login_failures
+SET failures = CASE
+        WHEN last_failure_at < sqlc.arg('since') THEN 1
+        ELSE failures + 1
+    END,
+    last_failure_at = sqlc.arg('at')
+WHERE key = sqlc.arg('key')
+AND failures = sqlc.arg('prev_failures')
+AND last_failure_at = sqlc.arg('prev_failure_at');
+
+-- name: RemoveLoginFailure :exec
+UPDATE login_failures
+SET failures = failures - 1
+WHERE key = $1 AND failures > 0;
+
+-- name: DeleteLoginFailures :exec
+DELETE FROM login_failures
+WHERE key = $1;
+
+-- name: DeleteStaleLoginFailures :exec
+DELETE FROM login_failures
+WHERE last_failure_at < $1;
@@ -0,0 +1,63 @@
+-- name: CreateModerationRule :one
+INSERT INTO moderation_rules (id, created_at, created_by, kind, pattern, action)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: ListModerationRules :many
+SELECT * FROM moderation_rules
+ORDER BY created_at, id;
+
+-- name: DeleteModerationRule :execrows
+DELETE FROM moderation_rules
+WHERE id = $1;
+
+-- name: CreateHeldChirp :one
+INSERT INTO held_chirps (id, created_at, user_id, body, in_reply_to, rechirp_of, chirp_id, reasons, status)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    'pending'
+)
+RETURNING *;
+
+-- name: GetHeldChirp :one
+SELECT * FROM held_chirps
+WHERE id = $1;
+
+-- name: ListHeldChirps :many
+SELECT * FROM held_chirps
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text)
+AND (
+        sqlc.narg('cursor_created_at')::timestamp IS NULL
+        OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+    )
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: ReviewHeldChirp :one
+UPDATE held_chirps
+SET status = $2,
+    reviewed_at = NOW(),
+    reviewed_by = $3
+WHERE id = $1
+AND status = 'pending'
+RETURNING *;
+
+-- name: SetHeldChirpChirp :one
+UPDATE held_chirps
+SET chirp_id = $2
+WHERE id = $1
+RETURNING *;
@@ -1,2 +1,4 @@
 -- name: Reset :exec
-DELETE FROM users;
\ No newline at end of file
+DELETE FROM webhook_events;
+DELETE FROM login_failures;
+DELETE FROM users;
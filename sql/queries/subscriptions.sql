@@ -0,0 +1,86 @@
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (user_id, created_at, updated_at, plan, status, expires_at)
+VALUES (
+    sqlc.arg('user_id'),
+    NOW(),
+    sqlc.arg('event_at'),
+    sqlc.arg('plan'),
+    'active',
+    sqlc.arg('expires_at')
+)
+ON CONFLICT (user_id) DO UPDATE
+SET plan = EXCLUDED.plan,
+    status = 'active',
+    expires_at = EXCLUDED.expires_at,
+    canceled_at = NULL,
+    updated_at = EXCLUDED.updated_at
+WHERE subscriptions.updated_at <= EXCLUDED.updated_at
+RETURNING *;
+
+-- name: CancelSubscription :one
+UPDATE subscriptions
+SET status = 'canceled',
+    canceled_at = NOW(),
+    expires_at = COALESCE(sqlc.narg('expires_at')::timestamp, expires_at),
+    updated_at = sqlc.arg('event_at')
+WHERE user_id = sqlc.arg('user_id')
+AND status <> 'ended'
+AND updated_at <= sqlc.arg('event_at')
+RETURNING *;
+
+-- name: MarkSubscriptionPastDue :one
+UPDATE subscriptions
+SET status = 'past_due',
+    expires_at = sqlc.arg('expires_at'),
+    updated_at = sqlc.arg('event_at')
+WHERE user_id = sqlc.arg('user_id')
+AND status <> 'ended'
+AND updated_at <= sqlc.arg('event_at')
+RETURNING *;
+
+-- name: EndSubscription :exec
+UPDATE subscriptions
+SET status = 'ended',
+    expires_at = NOW(),
+    updated_at = sqlc.arg('event_at')
+WHERE user_id = sqlc.arg('user_id')
+AND status <> 'ended'
+AND updated_at <= sqlc.arg('event_at');
+
+-- name: SyncChirpyRed :one
+UPDATE users
+SET is_chirpy_red = EXISTS (
+        SELECT 1 FROM subscriptions
+        WHERE subscriptions.user_id = users.id
+        AND subscriptions.status <> 'ended'
+        AND (subscriptions.expires_at IS NULL OR subscriptions.expires_at > NOW())
+    ),
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: EndLapsedSubscriptions :many
+-- updated_at is the time of the last event, which later events are
+-- compared against, so ending a lapsed subscription leaves it
+WITH lapsed AS (
+    UPDATE subscriptions
+    SET status = 'ended'
+    WHERE status <> 'ended'
+    AND expires_at <= NOW()
+    RETURNING user_id
+)
+UPDATE users
+SET is_chirpy_red = false,
+    updated_at = NOW()
+FROM lapsed
+WHERE users.id = lapsed.user_id
+RETURNING users.id;
+
+-- name: GetSubscription :one
+SELECT * FROM subscriptions
+WHERE user_id = $1;
+
+-- name: GetSubscriptionForUpdate :one
+SELECT * FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE;
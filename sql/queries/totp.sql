@@ -0,0 +1,51 @@
+-- name: UpsertPendingTOTPSecret :one
+INSERT INTO totp_secrets (user_id, created_at, secret)
+VALUES (
+    $1,
+    NOW(),
+    $2
+)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret,
+    created_at = EXCLUDED.created_at
+WHERE totp_secrets.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetTOTPSecret :one
+SELECT * FROM totp_secrets
+WHERE user_id = $1;
+
+-- name: ConfirmTOTPSecret :exec
+UPDATE totp_secrets
+SET confirmed_at = NOW(),
+    last_used_step = $2
+WHERE user_id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE totp_secrets
+SET last_used_step = $2
+WHERE user_id = $1
+AND last_used_step < $2;
+
+-- name: DeleteTOTPSecret :exec
+DELETE FROM totp_secrets
+WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1
+AND code_hash = $2
+AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
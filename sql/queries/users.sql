@@ -20,12 +20,19 @@ SELECT *
 FROM users
 WHERE email = $1;
 
--- name: UpdateUser :one
+-- name: UpdateUserEmail :one
 update users
 SET updated_at = NOW(),
-    email = $1,
-    hashed_password = $2
-where id = $3
+    email_verified_at = CASE WHEN email = $1 THEN email_verified_at END,
+    email = $1
+where id = $2
+RETURNING *;
+
+-- name: UpdateUserPassword :one
+update users
+SET updated_at = NOW(),
+    hashed_password = $1
+where id = $2
 RETURNING *;
 
 -- name: GetUserById :one
@@ -33,9 +40,32 @@ SELECT *
 FROM users
 WHERE id = $1;
 
--- name: UpgradeToChirpyRed :one
-update users
-SET is_chirpy_red = true,
+-- name: GetUserByHandle :one
+SELECT *
+FROM users
+WHERE handle = $1;
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET updated_at = NOW(),
+    handle = $1,
+    display_name = $2,
+    bio = $3,
+    avatar_url = $4
+WHERE id = $5
+RETURNING *;
+
+
+-- name: MarkEmailVerified :one
+UPDATE users
+SET email_verified_at = NOW(),
     updated_at = NOW()
 WHERE id = $1
-RETURNING *;
\ No newline at end of file
+AND email = $2
+RETURNING *;
+
+-- name: RehashUserPassword :exec
+UPDATE users
+SET hashed_password = sqlc.arg('new_hash')
+WHERE id = sqlc.arg('id')
+AND hashed_password = sqlc.arg('old_hash');
@@ -0,0 +1,54 @@
+-- name: ClaimWebhookEvent :one
+INSERT INTO webhook_events (id, event_id, received_at, updated_at, event_type, payload, status, attempts, sent_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    'processing',
+    1,
+    $4
+)
+ON CONFLICT (event_id) DO UPDATE
+SET status = 'processing',
+    attempts = webhook_events.attempts + 1,
+    updated_at = NOW()
+WHERE webhook_events.status = 'failed'
+OR (
+        webhook_events.status = 'processing'
+        AND webhook_events.updated_at < NOW() - INTERVAL '5 minutes'
+    )
+RETURNING *;
+
+-- name: ClaimFailedWebhookEvent :one
+UPDATE webhook_events
+SET status = 'processing',
+    attempts = attempts + 1,
+    updated_at = NOW()
+WHERE id = $1
+AND status = 'failed'
+RETURNING *;
+
+-- name: FinishWebhookEvent :one
+UPDATE webhook_events
+SET status = $2,
+    error = $3,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE id = $1;
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')::text)
+AND (
+        sqlc.narg('cursor_received_at')::timestamp IS NULL
+        OR (received_at, id) < (sqlc.narg('cursor_received_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+    )
+ORDER BY received_at DESC, id DESC
+LIMIT sqlc.arg('limit');
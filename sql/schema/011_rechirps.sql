@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN rechirp_of UUID REFERENCES chirps(id) ON DELETE CASCADE;
+
+CREATE INDEX chirps_rechirp_of_idx ON chirps (rechirp_of);
+
+-- a plain rechirp has no body of its own, and a user can only make one per chirp
+CREATE UNIQUE INDEX chirps_plain_rechirp_idx ON chirps (user_id, rechirp_of)
+WHERE body = '';
+
+-- +goose Down
+DROP INDEX chirps_plain_rechirp_idx;
+DROP INDEX chirps_rechirp_of_idx;
+
+ALTER TABLE chirps
+DROP COLUMN rechirp_of;
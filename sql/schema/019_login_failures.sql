@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE login_failures (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX login_failures_last_failure_at_idx ON login_failures (last_failure_at);
+
+-- +goose Down
+DROP TABLE login_failures;
@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE webhook_events (
+    id UUID PRIMARY KEY,
+    event_id TEXT NOT NULL UNIQUE,
+    received_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT,
+    attempts INTEGER NOT NULL
+);
+
+CREATE INDEX webhook_events_received_at_idx ON webhook_events (received_at, id);
+
+-- +goose Down
+DROP TABLE webhook_events;
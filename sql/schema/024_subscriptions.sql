@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE subscriptions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL,
+    expires_at TIMESTAMP,
+    canceled_at TIMESTAMP
+);
+
+CREATE INDEX subscriptions_expires_at_idx ON subscriptions (expires_at)
+WHERE status <> 'ended';
+
+-- users upgraded before subscriptions were tracked keep Chirpy Red
+INSERT INTO subscriptions (user_id, created_at, updated_at, plan, status)
+SELECT id, NOW(), NOW(), 'chirpy_red', 'active'
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscriptions;
@@ -0,0 +1,31 @@
+-- +goose Up
+CREATE TABLE moderation_rules (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    kind TEXT NOT NULL,
+    pattern TEXT NOT NULL,
+    action TEXT NOT NULL,
+    UNIQUE (kind, pattern)
+);
+
+CREATE TABLE held_chirps (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    in_reply_to UUID REFERENCES chirps(id) ON DELETE CASCADE,
+    rechirp_of UUID REFERENCES chirps(id) ON DELETE CASCADE,
+    -- the chirp a held edit is for, or the chirp an approved post became
+    chirp_id UUID REFERENCES chirps(id) ON DELETE CASCADE,
+    reasons TEXT[] NOT NULL,
+    status TEXT NOT NULL,
+    reviewed_at TIMESTAMP,
+    reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX held_chirps_created_at_idx ON held_chirps (created_at, id);
+
+-- +goose Down
+DROP TABLE held_chirps;
+DROP TABLE moderation_rules;
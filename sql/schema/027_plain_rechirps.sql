@@ -0,0 +1,32 @@
+-- +goose Up
+-- an empty body also means a deleted chirp, so plain rechirps are marked
+ALTER TABLE chirps
+ADD COLUMN is_plain_rechirp BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE chirps
+SET is_plain_rechirp = true
+WHERE rechirp_of IS NOT NULL
+AND body = ''
+AND deleted_at IS NULL;
+
+DROP INDEX chirps_plain_rechirp_idx;
+
+CREATE UNIQUE INDEX chirps_plain_rechirp_idx ON chirps (user_id, rechirp_of)
+WHERE is_plain_rechirp AND deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX chirps_plain_rechirp_idx;
+
+-- tombstones have an empty body too, and would collide with live plain
+-- rechirps in the old index. They lose the link to what they quoted; the
+-- old schema would have taken them for plain rechirps anyway.
+UPDATE chirps
+SET rechirp_of = NULL
+WHERE deleted_at IS NOT NULL
+AND rechirp_of IS NOT NULL;
+
+CREATE UNIQUE INDEX chirps_plain_rechirp_idx ON chirps (user_id, rechirp_of)
+WHERE body = '';
+
+ALTER TABLE chirps
+DROP COLUMN is_plain_rechirp;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- attempts are counted before they're checked, so the check needs the time
+-- of the attempt before
+ALTER TABLE login_failures
+ADD COLUMN previous_failure_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE login_failures
+DROP COLUMN previous_failure_at;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- the signed time of the first delivery, which orders events for a user
+ALTER TABLE webhook_events
+ADD COLUMN sent_at TIMESTAMP;
+
+UPDATE webhook_events
+SET sent_at = received_at;
+
+ALTER TABLE webhook_events
+ALTER COLUMN sent_at SET NOT NULL;
+
+-- +goose Down
+ALTER TABLE webhook_events
+DROP COLUMN sent_at;
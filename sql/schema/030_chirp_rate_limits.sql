@@ -0,0 +1,11 @@
+-- +goose Up
+-- posting attempts per user and hour, kept apart from chirps so deleting
+-- a chirp doesn't free a slot and held chirps count too
+CREATE TABLE chirp_rate_limits (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    window_start TIMESTAMP NOT NULL,
+    attempts INTEGER NOT NULL
+);
+
+-- +goose Down
+DROP TABLE chirp_rate_limits;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- attempts are only counted once they're allowed, compared and swapped
+-- against the record they were checked with, so this isn't needed
+ALTER TABLE login_failures
+DROP COLUMN previous_failure_at;
+
+-- +goose Down
+ALTER TABLE login_failures
+ADD COLUMN previous_failure_at TIMESTAMP;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- back to the stored vector from 007: search reads it instead of parsing
+-- every matching body again to rank it
+DROP INDEX chirps_search_idx;
+
+ALTER TABLE chirps
+ADD COLUMN search_vector tsvector NOT NULL
+GENERATED ALWAYS AS (to_tsvector('english', body)) STORED;
+
+CREATE INDEX chirps_search_vector_idx ON chirps USING GIN (search_vector);
+
+-- +goose Down
+DROP INDEX chirps_search_vector_idx;
+
+ALTER TABLE chirps
+DROP COLUMN search_vector;
+
+CREATE INDEX chirps_search_idx ON chirps USING GIN (to_tsvector('english', body));
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const subscriptionSweepInterval = time.Minute
+
+// runSubscriptionSweeper ends subscriptions that ran out, canceled or
+// unpaid ones mostly, and takes Chirpy Red away from their users. Polka
+// doesn't send an event when a period simply ends.
+func (cfg *apiConfig) runSubscriptionSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cfg.sweepSubscriptions(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) sweepSubscriptions(ctx context.Context) {
+	// with several instances they may sweep at once, which is harmless
+	userIDs, err := cfg.db.EndLapsedSubscriptions(ctx)
+	if err != nil {
+		log.Printf("Couldn't end lapsed subscriptions: %s", err)
+		return
+	}
+	for _, userID := range userIDs {
+		log.Printf("Chirpy Red subscription of user %s lapsed", userID)
+	}
+}